@@ -0,0 +1,204 @@
+package piper
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"golang.org/x/crypto/pbkdf2"
+	"gopkg.in/yaml.v3"
+)
+
+// DirectoryVariables looks up each var as a file named after it, like a
+// mounted Kubernetes secret. A var naming a directory resolves to a mapping
+// of its files, so ((creds.user)) reads the file creds/user.
+type DirectoryVariables struct {
+	Path string
+}
+
+func (v DirectoryVariables) Get(name string) (interface{}, bool, error) {
+	if name == "" || strings.Contains(name, "..") || strings.ContainsAny(name, `/\`) {
+		return nil, false, nil
+	}
+
+	return readVariablePath(filepath.Join(v.Path, name))
+}
+
+func readVariablePath(path string) (interface{}, bool, error) {
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	if !info.IsDir() {
+		contents, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, false, err
+		}
+
+		return strings.TrimSuffix(string(contents), "\n"), true, nil
+	}
+
+	files, err := ioutil.ReadDir(path)
+	if err != nil {
+		return nil, false, err
+	}
+
+	values := map[string]interface{}{}
+	for _, file := range files {
+		if strings.HasPrefix(file.Name(), ".") {
+			continue
+		}
+
+		value, _, err := readVariablePath(filepath.Join(path, file.Name()))
+		if err != nil {
+			return nil, false, err
+		}
+		values[file.Name()] = value
+	}
+
+	return values, true, nil
+}
+
+var environmentVariableNamePattern = regexp.MustCompile(`[^A-Za-z0-9]+`)
+
+// EnvironmentVariables looks up each var in the given environment as the
+// prefix followed by the upper-cased var name, so with a prefix of PIPER_VAR_
+// ((api-token)) reads PIPER_VAR_API_TOKEN.
+type EnvironmentVariables struct {
+	Prefix      string
+	Environment []string
+}
+
+func (v EnvironmentVariables) Get(name string) (interface{}, bool, error) {
+	key := v.Prefix + strings.ToUpper(environmentVariableNamePattern.ReplaceAllString(name, "_"))
+
+	for _, variable := range v.Environment {
+		parts := strings.SplitN(variable, "=", 2)
+		if len(parts) == 2 && parts[0] == key {
+			return parts[1], true, nil
+		}
+	}
+
+	return nil, false, nil
+}
+
+// LoadEncryptedFileVariables reads the vars of a YAML vars file encrypted with
+// `openssl enc -aes-256-cbc -pbkdf2 -salt`. The file is decrypted once, as
+// deriving the key is slow by design.
+func LoadEncryptedFileVariables(path, passphrase string) (StaticVariables, error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	plaintext, err := decryptOpenSSL(contents, passphrase)
+	if err != nil {
+		return nil, fmt.Errorf("could not decrypt vars file %s: %s", path, err)
+	}
+
+	var variables map[string]interface{}
+	err = yaml.Unmarshal(plaintext, &variables)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse vars file %s: %s", path, err)
+	}
+
+	return StaticVariables(variables), nil
+}
+
+const (
+	openSSLSaltHeader = "Salted__"
+	openSSLIterations = 10000
+)
+
+func decryptOpenSSL(contents []byte, passphrase string) ([]byte, error) {
+	if !bytes.HasPrefix(contents, []byte(openSSLSaltHeader)) || len(contents) < len(openSSLSaltHeader)+8+aes.BlockSize {
+		return nil, errors.New("not an openssl salted file")
+	}
+
+	salt := contents[len(openSSLSaltHeader) : len(openSSLSaltHeader)+8]
+	ciphertext := contents[len(openSSLSaltHeader)+8:]
+	if len(ciphertext)%aes.BlockSize != 0 {
+		return nil, errors.New("ciphertext is not a multiple of the block size")
+	}
+
+	keyAndIV := pbkdf2.Key([]byte(passphrase), salt, openSSLIterations, 32+aes.BlockSize, sha256.New)
+	block, err := aes.NewCipher(keyAndIV[:32])
+	if err != nil {
+		return nil, err
+	}
+
+	plaintext := make([]byte, len(ciphertext))
+	cipher.NewCBCDecrypter(block, keyAndIV[32:]).CryptBlocks(plaintext, ciphertext)
+
+	padding := int(plaintext[len(plaintext)-1])
+	if padding == 0 || padding > aes.BlockSize || !bytes.HasSuffix(plaintext, bytes.Repeat([]byte{byte(padding)}, padding)) {
+		return nil, errors.New("bad passphrase or corrupt file")
+	}
+
+	return plaintext[:len(plaintext)-padding], nil
+}
+
+// MultiVariables looks up a var in each of its Variables in order and returns
+// the first value found.
+type MultiVariables []Variables
+
+func (v MultiVariables) Get(name string) (interface{}, bool, error) {
+	for _, variables := range v {
+		value, found, err := variables.Get(name)
+		if err != nil || found {
+			return value, found, err
+		}
+	}
+
+	return nil, false, nil
+}
+
+// RedactingWriter masks every occurrence of the given secrets in what is
+// written through it. Secrets shorter than MinRedactedLength are left alone.
+type RedactingWriter struct {
+	Writer  io.Writer
+	Secrets []string
+}
+
+const Redacted = "[redacted]"
+
+// MinRedactedLength is the length of the shortest secret that is searched for
+// in text. Masking every occurrence of a value like 1 or true would garble
+// paths, flags and image names, so shorter secrets are only masked where their
+// value is printed.
+const MinRedactedLength = 6
+
+func (w RedactingWriter) Write(p []byte) (int, error) {
+	secrets := append([]string{}, w.Secrets...)
+	sort.Slice(secrets, func(i, j int) bool {
+		return len(secrets[i]) > len(secrets[j])
+	})
+
+	redacted := string(p)
+	for _, secret := range secrets {
+		if len(secret) >= MinRedactedLength {
+			redacted = strings.Replace(redacted, secret, Redacted, -1)
+		}
+	}
+
+	_, err := io.WriteString(w.Writer, redacted)
+	if err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
@@ -0,0 +1,195 @@
+package piper_test
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha256"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/ryanmoran/piper"
+	"golang.org/x/crypto/pbkdf2"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// encryptOpenSSL produces the same output as
+// `openssl enc -aes-256-cbc -pbkdf2 -salt`. The salt is fixed so that
+// decrypting with a wrong passphrase fails the same way on every run, rather
+// than leaving valid padding by chance.
+func encryptOpenSSL(plaintext []byte, passphrase string) []byte {
+	salt := []byte("somesalt")
+
+	keyAndIV := pbkdf2.Key([]byte(passphrase), salt, 10000, 48, sha256.New)
+
+	block, err := aes.NewCipher(keyAndIV[:32])
+	Expect(err).NotTo(HaveOccurred())
+
+	padding := aes.BlockSize - len(plaintext)%aes.BlockSize
+	plaintext = append(plaintext, bytes.Repeat([]byte{byte(padding)}, padding)...)
+
+	ciphertext := make([]byte, len(plaintext))
+	cipher.NewCBCEncrypter(block, keyAndIV[32:]).CryptBlocks(ciphertext, plaintext)
+
+	return append(append([]byte("Salted__"), salt...), ciphertext...)
+}
+
+var _ = Describe("DirectoryVariables", func() {
+	var varsDir string
+
+	BeforeEach(func() {
+		var err error
+		varsDir, err = ioutil.TempDir("", "")
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(ioutil.WriteFile(filepath.Join(varsDir, "api-token"), []byte("some-token\n"), 0600)).To(Succeed())
+		Expect(os.Mkdir(filepath.Join(varsDir, "creds"), 0700)).To(Succeed())
+		Expect(ioutil.WriteFile(filepath.Join(varsDir, "creds", "user"), []byte("some-user"), 0600)).To(Succeed())
+		Expect(ioutil.WriteFile(filepath.Join(varsDir, "creds", ".hidden"), []byte("hidden"), 0600)).To(Succeed())
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(varsDir)).To(Succeed())
+	})
+
+	It("reads the file named after the var", func() {
+		value, found, err := piper.DirectoryVariables{Path: varsDir}.Get("api-token")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(found).To(BeTrue())
+		Expect(value).To(Equal("some-token"))
+	})
+
+	It("reads a directory as a mapping of its files", func() {
+		value, found, err := piper.DirectoryVariables{Path: varsDir}.Get("creds")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(found).To(BeTrue())
+		Expect(value).To(Equal(map[string]interface{}{"user": "some-user"}))
+	})
+
+	It("does not find missing vars or vars outside of the directory", func() {
+		for _, name := range []string{"no-such-var", "../etc", "creds/user"} {
+			_, found, err := piper.DirectoryVariables{Path: varsDir}.Get(name)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(found).To(BeFalse())
+		}
+	})
+})
+
+var _ = Describe("EnvironmentVariables", func() {
+	It("reads the prefixed, upper-cased var name from the environment", func() {
+		variables := piper.EnvironmentVariables{
+			Prefix:      "PIPER_VAR_",
+			Environment: []string{"API_TOKEN=wrong-token", "PIPER_VAR_API_TOKEN=some=token", "PIPER_VAR_EMPTY="},
+		}
+
+		value, found, err := variables.Get("api-token")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(found).To(BeTrue())
+		Expect(value).To(Equal("some=token"))
+
+		value, found, err = variables.Get("empty")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(found).To(BeTrue())
+		Expect(value).To(Equal(""))
+
+		_, found, err = variables.Get("missing")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(found).To(BeFalse())
+	})
+})
+
+var _ = Describe("LoadEncryptedFileVariables", func() {
+	var varsFilePath string
+
+	BeforeEach(func() {
+		tempFile, err := ioutil.TempFile("", "")
+		Expect(err).NotTo(HaveOccurred())
+
+		_, err = tempFile.Write(encryptOpenSSL([]byte("api-token: some-token\ncreds: {user: some-user}\n"), "some-passphrase"))
+		Expect(err).NotTo(HaveOccurred())
+
+		varsFilePath = tempFile.Name()
+		Expect(tempFile.Close()).To(Succeed())
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(varsFilePath)).To(Succeed())
+	})
+
+	It("reads vars from the decrypted file", func() {
+		variables, err := piper.LoadEncryptedFileVariables(varsFilePath, "some-passphrase")
+		Expect(err).NotTo(HaveOccurred())
+
+		value, found, err := variables.Get("api-token")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(found).To(BeTrue())
+		Expect(value).To(Equal("some-token"))
+
+		value, found, err = variables.Get("creds")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(found).To(BeTrue())
+		Expect(value).To(Equal(map[string]interface{}{"user": "some-user"}))
+	})
+
+	Context("failure cases", func() {
+		It("returns an error when the passphrase is wrong", func() {
+			_, err := piper.LoadEncryptedFileVariables(varsFilePath, "wrong-passphrase")
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(HavePrefix("could not decrypt vars file " + varsFilePath))
+		})
+
+		It("returns an error when the file is not encrypted", func() {
+			Expect(ioutil.WriteFile(varsFilePath, []byte("api-token: some-token"), 0600)).To(Succeed())
+
+			_, err := piper.LoadEncryptedFileVariables(varsFilePath, "some-passphrase")
+			Expect(err).To(MatchError(ContainSubstring("not an openssl salted file")))
+		})
+	})
+})
+
+var _ = Describe("MultiVariables", func() {
+	It("returns the first value found", func() {
+		variables := piper.MultiVariables{
+			piper.StaticVariables{"first": "first-value"},
+			piper.StaticVariables{"first": "other-value", "second": "second-value"},
+		}
+
+		value, found, err := variables.Get("first")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(found).To(BeTrue())
+		Expect(value).To(Equal("first-value"))
+
+		value, found, err = variables.Get("second")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(found).To(BeTrue())
+		Expect(value).To(Equal("second-value"))
+
+		_, found, err = variables.Get("third")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(found).To(BeFalse())
+	})
+})
+
+var _ = Describe("RedactingWriter", func() {
+	It("masks the secrets", func() {
+		buffer := bytes.NewBuffer([]byte{})
+		writer := piper.RedactingWriter{Writer: buffer, Secrets: []string{"secret", "some-secret", ""}}
+
+		n, err := writer.Write([]byte("--env=TOKEN=some-secret --env=OTHER=secret\n"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(n).To(Equal(43))
+		Expect(buffer.String()).To(Equal("--env=TOKEN=[redacted] --env=OTHER=[redacted]\n"))
+	})
+
+	It("leaves secrets that are too short to search for", func() {
+		buffer := bytes.NewBuffer([]byte{})
+		writer := piper.RedactingWriter{Writer: buffer, Secrets: []string{"1", "true"}}
+
+		_, err := writer.Write([]byte("docker run --volume=/tmp/input-1:/tmp/build/input-1 --privileged=true my-image:1.0\n"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(buffer.String()).To(Equal("docker run --volume=/tmp/input-1:/tmp/build/input-1 --privileged=true my-image:1.0\n"))
+	})
+})
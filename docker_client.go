@@ -1,10 +1,19 @@
 package piper
 
 import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"fmt"
 	"io"
+	"io/ioutil"
+	"os"
 	"os/exec"
+	"path/filepath"
 	"strings"
+	"syscall"
+	"time"
 )
 
 type DockerVolumeMount struct {
@@ -17,33 +26,244 @@ func (m DockerVolumeMount) String() string {
 }
 
 type DockerEnv struct {
-	Key   string
-	Value string
+	Key    string
+	Value  string
+	Secret bool
+	Source EnvSource
 }
 
 func (e DockerEnv) String() string {
 	return fmt.Sprintf("--env=%s=%s", e.Key, e.Value)
 }
 
+// RedactedString formats the variable like String, but masks the value of a
+// secret so that it can be printed.
+func (e DockerEnv) RedactedString() string {
+	if e.Secret {
+		return fmt.Sprintf("--env=%s=%s", e.Key, Redacted)
+	}
+
+	return e.String()
+}
+
+func (e DockerEnv) envFileLine(redacted bool) string {
+	if redacted && e.Secret {
+		return fmt.Sprintf("%s=%s", e.Key, Redacted)
+	}
+
+	return fmt.Sprintf("%s=%s", e.Key, e.Value)
+}
+
+// envFilePlaceholder stands in for the path of the env file in dry-run
+// output, where no file is written.
+const envFilePlaceholder = "<env-file>"
+
+type DockerRegistryAuth struct {
+	Registry string
+	Username string
+	Password string
+}
+
+// ContainerExitError is returned when the task container exits with a
+// non-zero status.
+type ContainerExitError struct {
+	Status      int
+	ContainerID string
+}
+
+func (e ContainerExitError) Error() string {
+	if e.ContainerID != "" {
+		return fmt.Sprintf("container %s exited with status %d", e.ContainerID, e.Status)
+	}
+
+	return fmt.Sprintf("task exited with status %d", e.Status)
+}
+
+// runtimeErrorStatus is the exit status of docker run, podman run and
+// nerdctl run when they fail to run the container themselves.
+const runtimeErrorStatus = 125
+
+type DockerRunOptions struct {
+	Workdir    string
+	User       string
+	Limits     ContainerLimits
+	Privileged bool
+	Rm         bool
+
+	// EnvFile passes the env vars to docker in a temporary --env-file
+	// instead of as --env arguments, which are visible in ps.
+	EnvFile bool
+
+	// HostUser runs the task as the current host user, overriding User, so
+	// that outputs stay writable.
+	HostUser bool
+
+	// Name names the container so that it can be stopped when the run is
+	// cancelled. StopGracePeriod is how long a stopped container is given to
+	// exit before it is killed, 10 seconds by default.
+	Name            string
+	StopGracePeriod time.Duration
+
+	// KeepOnFailure keeps the named container when the task fails, even when
+	// Rm is set, so that it can be intercepted. The container is then removed
+	// by piper once the task succeeds, instead of by docker run --rm.
+	KeepOnFailure bool
+
+	// Tty allocates a pseudo-terminal for the task. Interactive keeps the
+	// stdin of the task open and passes the Stdin of the client through to it.
+	Tty         bool
+	Interactive bool
+}
+
+func (o DockerRunOptions) keepOnFailure() bool {
+	return o.KeepOnFailure && o.Name != ""
+}
+
+const defaultStopGracePeriod = 10 * time.Second
+
+func (o DockerRunOptions) stopGracePeriod() time.Duration {
+	if o.StopGracePeriod == 0 {
+		return defaultStopGracePeriod
+	}
+
+	return o.StopGracePeriod
+}
+
+// NewContainerName generates a container name of the form piper-<random>.
+func NewContainerName() (string, error) {
+	suffix := make([]byte, 6)
+	_, err := rand.Read(suffix)
+	if err != nil {
+		return "", fmt.Errorf("could not generate a container name: %s", err)
+	}
+
+	return fmt.Sprintf("piper-%s", hex.EncodeToString(suffix)), nil
+}
+
+func (o DockerRunOptions) user() string {
+	if o.HostUser {
+		return fmt.Sprintf("%d:%d", os.Getuid(), os.Getgid())
+	}
+
+	return o.User
+}
+
+// DockerClient runs the docker CLI. Command is used as a template for each
+// invocation, so a client can run several commands.
 type DockerClient struct {
 	Command *exec.Cmd
+	Stdin   io.Reader
 	Stdout  io.Writer
 	Stderr  io.Writer
+
+	// RegistryConfigDir keeps the credentials of Login, and the Pull that
+	// uses them, out of the user's config when it is set.
+	RegistryConfigDir string
+}
+
+func (c DockerClient) command(args ...string) *exec.Cmd {
+	return &exec.Cmd{
+		Path:   c.Command.Path,
+		Args:   append(append([]string{}, c.Command.Args...), args...),
+		Err:    c.Command.Err,
+		Stdout: c.Stdout,
+		Stderr: c.Stderr,
+	}
+}
+
+// registryCommand is like command, but points the CLI at RegistryConfigDir
+// when it is set. docker and nerdctl read DOCKER_CONFIG, podman reads
+// REGISTRY_AUTH_FILE.
+func (c DockerClient) registryCommand(args ...string) *exec.Cmd {
+	cmd := c.command(args...)
+	if c.RegistryConfigDir != "" {
+		cmd.Env = append(os.Environ(),
+			fmt.Sprintf("DOCKER_CONFIG=%s", c.RegistryConfigDir),
+			fmt.Sprintf("REGISTRY_AUTH_FILE=%s", filepath.Join(c.RegistryConfigDir, "auth.json")),
+		)
+	}
+
+	return cmd
+}
+
+func (c DockerClient) Login(auth DockerRegistryAuth, dryRun bool) error {
+	command := c.registryCommand("login", fmt.Sprintf("--username=%s", auth.Username), "--password-stdin")
+	if auth.Registry != "" {
+		command.Args = append(command.Args, auth.Registry)
+	}
+
+	if dryRun {
+		fmt.Fprintln(c.Stdout, strings.Join(command.Args, " "))
+		return nil
+	}
+
+	command.Stdin = strings.NewReader(auth.Password)
+
+	err := command.Run()
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// missingImagePatterns match the errors the runtimes print when inspecting an
+// image that is not present locally.
+var missingImagePatterns = []string{"no such image", "image not known"}
+
+// ImageExists reports whether the image is present locally. It is looked for
+// even in dry-run, as that changes nothing. Only a missing image is reported
+// as not present, any other failure to inspect it is returned as an error.
+func (c DockerClient) ImageExists(image string) (bool, error) {
+	cmd := c.command("image", "inspect", "--format={{.Id}}", image)
+	cmd.Stdout = ioutil.Discard
+
+	var output bytes.Buffer
+	cmd.Stderr = &output
+
+	err := cmd.Run()
+	if _, ok := err.(*exec.ExitError); ok {
+		lowered := strings.ToLower(output.String())
+		for _, pattern := range missingImagePatterns {
+			if strings.Contains(lowered, pattern) {
+				return false, nil
+			}
+		}
+
+		message := strings.TrimSpace(output.String())
+		if len(message) == 0 {
+			message = err.Error()
+		}
+		return false, fmt.Errorf("could not inspect image %s: %s", image, message)
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
 }
 
 func (c DockerClient) Pull(image string, dryRun bool) error {
-	args := append(c.Command.Args, "pull", image)
+	command := c.registryCommand("pull", image)
 
 	if dryRun {
-		fmt.Fprintln(c.Stdout, strings.Join(args, " "))
+		fmt.Fprintln(c.Stdout, strings.Join(command.Args, " "))
 		return nil
 	}
 
-	c.Command.Args = args
-	c.Command.Stdout = c.Stdout
-	c.Command.Stderr = c.Stderr
+	var output bytes.Buffer
+	command.Stderr = &output
+	if c.Stderr != nil {
+		command.Stderr = io.MultiWriter(c.Stderr, &output)
+	}
 
-	err := c.Command.Run()
+	err := command.Run()
+	if _, ok := err.(*exec.ExitError); ok {
+		if output.Len() == 0 {
+			return PullError{Image: image, Kind: PullUnknownError, Message: err.Error()}
+		}
+		return classifyPullError(image, output.String())
+	}
 	if err != nil {
 		return err
 	}
@@ -56,44 +276,216 @@ func (c DockerClient) Run(
 	image string,
 	envVars []DockerEnv,
 	mounts []DockerVolumeMount,
-	privileged bool,
+	options DockerRunOptions,
+	dryRun bool,
+) error {
+	return c.RunContext(context.Background(), command, image, envVars, mounts, options, dryRun)
+}
+
+// RunContext runs the task container until it exits or ctx is done. When ctx
+// is done, the signal is forwarded to the docker CLI and the named container
+// is stopped, killed if it does not stop within the grace period, and removed
+// when options.Rm is set. It then returns ctx.Err().
+func (c DockerClient) RunContext(
+	ctx context.Context,
+	command []string,
+	image string,
+	envVars []DockerEnv,
+	mounts []DockerVolumeMount,
+	options DockerRunOptions,
 	dryRun bool,
-	rm bool,
 ) error {
-	c.Command.Args = append(c.Command.Args, "run", fmt.Sprintf("--workdir=%s", VolumeMountPoint))
+	return c.run(ctx, command, image, envVars, mounts, options, dryRun, nil)
+}
 
-	if privileged {
-		c.Command.Args = append(c.Command.Args, "--privileged")
+// run runs the task container, adding the runtime specific runArgs to those
+// the docker CLI understands.
+func (c DockerClient) run(
+	ctx context.Context,
+	command []string,
+	image string,
+	envVars []DockerEnv,
+	mounts []DockerVolumeMount,
+	options DockerRunOptions,
+	dryRun bool,
+	runArgs []string,
+) error {
+	workdir := options.Workdir
+	if workdir == "" {
+		workdir = VolumeMountPoint
 	}
 
-	if rm {
-		c.Command.Args = append(c.Command.Args, "--rm")
+	cmd := c.command("run", fmt.Sprintf("--workdir=%s", workdir))
+	if options.Name != "" {
+		cmd.Args = append(cmd.Args, fmt.Sprintf("--name=%s", options.Name))
 	}
+	cmd.Args = append(cmd.Args, runArgs...)
 
-	for _, envVar := range envVars {
-		c.Command.Args = append(c.Command.Args, envVar.String())
+	if user := options.user(); user != "" {
+		cmd.Args = append(cmd.Args, fmt.Sprintf("--user=%s", user))
+	}
+
+	if options.Limits.CPU != 0 {
+		cmd.Args = append(cmd.Args, fmt.Sprintf("--cpu-shares=%d", options.Limits.CPU))
+	}
+
+	if options.Limits.Memory != 0 {
+		cmd.Args = append(cmd.Args, fmt.Sprintf("--memory=%d", options.Limits.Memory))
+	}
+
+	if options.Privileged {
+		cmd.Args = append(cmd.Args, "--privileged")
+	}
+
+	if options.Rm && !options.keepOnFailure() {
+		cmd.Args = append(cmd.Args, "--rm")
+	}
+
+	envStart := len(cmd.Args)
+	if options.EnvFile {
+		envFilePath := envFilePlaceholder
+		if !dryRun {
+			var err error
+			envFilePath, err = writeEnvFile(envVars)
+			if err != nil {
+				return err
+			}
+			defer os.Remove(envFilePath)
+		}
+
+		cmd.Args = append(cmd.Args, fmt.Sprintf("--env-file=%s", envFilePath))
+	} else {
+		for _, envVar := range envVars {
+			cmd.Args = append(cmd.Args, envVar.String())
+		}
 	}
 
 	for _, mount := range mounts {
-		c.Command.Args = append(c.Command.Args, mount.String())
+		cmd.Args = append(cmd.Args, mount.String())
+	}
+
+	if options.Interactive {
+		cmd.Args = append(cmd.Args, "--interactive")
+		cmd.Stdin = c.Stdin
+	}
+
+	if options.Tty {
+		cmd.Args = append(cmd.Args, "--tty")
 	}
 
-	c.Command.Args = append(c.Command.Args, "--tty")
-	c.Command.Args = append(c.Command.Args, image)
-	c.Command.Args = append(c.Command.Args, command...)
+	cmd.Args = append(cmd.Args, image)
+	cmd.Args = append(cmd.Args, command...)
 
 	if dryRun {
-		fmt.Fprintln(c.Stdout, strings.Join(c.Command.Args, " "))
+		displayArgs := append([]string{}, cmd.Args...)
+		if !options.EnvFile {
+			for i, envVar := range envVars {
+				displayArgs[envStart+i] = envVar.RedactedString()
+			}
+		}
+
+		fmt.Fprintln(c.Stdout, strings.Join(displayArgs, " "))
+
+		if options.EnvFile {
+			fmt.Fprintf(c.Stdout, "# %s\n", envFilePlaceholder)
+			for _, envVar := range envVars {
+				fmt.Fprintf(c.Stdout, "# %s\n", envVar.envFileLine(true))
+			}
+		}
 		return nil
 	}
 
-	c.Command.Stdout = c.Stdout
-	c.Command.Stderr = c.Stderr
-
-	err := c.Command.Run()
+	err := cmd.Start()
 	if err != nil {
 		return err
 	}
 
-	return nil
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
+
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		cmd.Process.Signal(syscall.SIGTERM)
+		if options.Name != "" {
+			c.stop(options)
+		}
+		<-done
+
+		if options.Name != "" && options.Rm {
+			c.quiet(c.command("rm", "--force", options.Name)).Run()
+		}
+
+		return ctx.Err()
+	}
+
+	if err == nil && options.Rm && options.keepOnFailure() {
+		c.quiet(c.command("rm", "--force", options.Name)).Run()
+	}
+
+	return exitStatusError(err)
+}
+
+// exitStatusError returns a non-zero exit status of a command run in a
+// container as a ContainerExitError, and other errors as they are.
+func exitStatusError(err error) error {
+	if exitError, ok := err.(*exec.ExitError); ok {
+		status := exitError.ExitCode()
+		if status > 0 && status != runtimeErrorStatus {
+			return ContainerExitError{Status: status}
+		}
+	}
+
+	return err
+}
+
+// stop stops the container, giving it the grace period to exit, and kills it
+// if it could not be stopped.
+func (c DockerClient) stop(options DockerRunOptions) {
+	seconds := int(options.stopGracePeriod().Round(time.Second) / time.Second)
+
+	err := c.quiet(c.command("stop", fmt.Sprintf("--time=%d", seconds), options.Name)).Run()
+	if err != nil {
+		c.quiet(c.command("kill", options.Name)).Run()
+	}
+}
+
+// quiet discards the output of commands that clean up after the task, like
+// the container names printed by docker stop.
+func (c DockerClient) quiet(cmd *exec.Cmd) *exec.Cmd {
+	cmd.Stdout = ioutil.Discard
+	return cmd
+}
+
+// writeEnvFile writes the env vars to a temporary file that only the current
+// user can read, in the KEY=VALUE format of docker run --env-file.
+func writeEnvFile(envVars []DockerEnv) (string, error) {
+	var contents strings.Builder
+	for _, envVar := range envVars {
+		if strings.ContainsAny(envVar.Value, "\r\n") {
+			return "", fmt.Errorf("cannot pass env var %q in an env file: its value contains a newline", envVar.Key)
+		}
+
+		contents.WriteString(envVar.envFileLine(false))
+		contents.WriteString("\n")
+	}
+
+	file, err := ioutil.TempFile("", "piper-env-")
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	err = file.Chmod(0600)
+	if err == nil {
+		_, err = file.WriteString(contents.String())
+	}
+	if err != nil {
+		os.Remove(file.Name())
+		return "", err
+	}
+
+	return file.Name(), nil
 }
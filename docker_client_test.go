@@ -2,12 +2,19 @@ package piper_test
 
 import (
 	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
 	"os/exec"
+	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/ryanmoran/piper"
 
 	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
 	. "github.com/onsi/gomega"
 )
 
@@ -27,6 +34,101 @@ var _ = Describe("DockerClient", func() {
 		}
 	})
 
+	Describe("Login", func() {
+		It("logs in to the registry with the password on stdin", func() {
+			client.Command = exec.Command("sh", "-c", `echo "$@" && cat`, "--")
+			err := client.Login(piper.DockerRegistryAuth{
+				Registry: "registry.example.com",
+				Username: "some-user",
+				Password: "some-password",
+			}, false)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(stdout.String()).To(Equal("login --username=some-user --password-stdin registry.example.com\nsome-password"))
+		})
+
+		It("prints the docker command without the password", func() {
+			err := client.Login(piper.DockerRegistryAuth{
+				Username: "some-user",
+				Password: "some-password",
+			}, true)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(stdout.String()).To(Equal("echo login --username=some-user --password-stdin\n"))
+		})
+
+		It("keeps the credentials in the registry config dir for the pull", func() {
+			client.Command = exec.Command("sh", "-c", `echo "$1 $DOCKER_CONFIG $REGISTRY_AUTH_FILE"`, "--")
+			client.RegistryConfigDir = "/some/config-dir"
+
+			err := client.Login(piper.DockerRegistryAuth{Username: "some-user", Password: "some-password"}, false)
+			Expect(err).NotTo(HaveOccurred())
+
+			err = client.Pull("some-image", false)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(stdout.String()).To(Equal(`login /some/config-dir /some/config-dir/auth.json
+pull /some/config-dir /some/config-dir/auth.json
+`))
+		})
+	})
+
+	Describe("ImageExists", func() {
+		It("inspects the image", func() {
+			client.Command = exec.Command("sh", "-c", `echo "$@" >&2`, "--")
+			stderr := bytes.NewBuffer([]byte{})
+			client.Stderr = stderr
+
+			exists, err := client.ImageExists("some-image")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(exists).To(BeTrue())
+
+			Expect(stdout.String()).To(BeEmpty())
+			Expect(stderr.String()).To(BeEmpty())
+		})
+
+		It("reports a missing image", func() {
+			client.Command = exec.Command("sh", "-c", `echo "Error: No such image: $4" >&2; exit 1`, "--")
+
+			exists, err := client.ImageExists("some-image")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(exists).To(BeFalse())
+		})
+
+		It("reports an image missing from podman", func() {
+			client.Command = exec.Command("sh", "-c", `echo "Error: $4: image not known" >&2; exit 125`, "--")
+
+			exists, err := client.ImageExists("some-image")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(exists).To(BeFalse())
+		})
+
+		Context("failure cases", func() {
+			It("returns an error when the image cannot be inspected", func() {
+				client.Command = exec.Command("sh", "-c", `echo "Cannot connect to the Docker daemon at unix:///var/run/docker.sock. Is the docker daemon running?" >&2; exit 1`, "--")
+
+				_, err := client.ImageExists("some-image")
+				Expect(err).To(MatchError("could not inspect image some-image: Cannot connect to the Docker daemon at unix:///var/run/docker.sock. Is the docker daemon running?"))
+			})
+
+			It("returns the exit status when the runtime prints no error", func() {
+				client.Command = exec.Command("false")
+
+				_, err := client.ImageExists("some-image")
+				Expect(err).To(MatchError("could not inspect image some-image: exit status 1"))
+			})
+
+			Context("when the executable cannot be found", func() {
+				It("returns an error", func() {
+					client.Command = exec.Command("no-such-executable")
+
+					_, err := client.ImageExists("some-image")
+					Expect(err).To(MatchError(ContainSubstring("executable file not found in $PATH")))
+				})
+			})
+		})
+	})
+
 	Describe("Pull", func() {
 		It("pulls the specified docker image", func() {
 			err := client.Pull("some-image", false)
@@ -43,6 +145,52 @@ var _ = Describe("DockerClient", func() {
 		})
 
 		Context("failure cases", func() {
+			It("classifies the error from the output of the runtime, passing the output through", func() {
+				client.Command = exec.Command("sh", "-c", `echo "Using default tag: latest" >&2; echo "Error response from daemon: toomanyrequests: You have reached your pull rate limit." >&2; exit 1`, "--")
+				stderr := bytes.NewBuffer([]byte{})
+				client.Stderr = stderr
+
+				err := client.Pull("some-image", false)
+				Expect(err).To(Equal(piper.PullError{
+					Image:   "some-image",
+					Kind:    piper.PullRateLimited,
+					Message: "Error response from daemon: toomanyrequests: You have reached your pull rate limit.",
+				}))
+				Expect(err).To(MatchError("could not pull some-image: rate limited (log in to the registry or wait for the limit to reset): Error response from daemon: toomanyrequests: You have reached your pull rate limit."))
+
+				Expect(stderr.String()).To(ContainSubstring("Using default tag: latest\n"))
+			})
+
+			DescribeTable("classifying the error",
+				func(output string, kind piper.PullErrorKind) {
+					client.Command = exec.Command("sh", "-c", `echo "$0" >&2; exit 1`, output)
+
+					err := client.Pull("some-image", false)
+					Expect(err).To(BeAssignableToTypeOf(piper.PullError{}))
+					Expect(err.(piper.PullError).Kind).To(Equal(kind))
+				},
+				Entry("missing tag", "Error response from daemon: manifest for some-image:1.0 not found: manifest unknown: manifest unknown", piper.PullNotFound),
+				Entry("missing repository", "Error response from daemon: pull access denied for some-image, repository does not exist or may require 'docker login': denied: requested access to the resource is denied", piper.PullNotFoundOrDenied),
+				Entry("bad credentials", "Error response from daemon: Head \"https://registry.example.com/v2/some-image/manifests/latest\": unauthorized: authentication required", piper.PullAuthDenied),
+				Entry("unreachable registry", "Error response from daemon: Get \"https://registry.example.com/v2/\": dial tcp 10.0.0.1:443: i/o timeout", piper.PullNetworkError),
+				Entry("failing registry", "Error response from daemon: received unexpected HTTP status: 503 Service Unavailable", piper.PullRegistryError),
+				Entry("anything else", "Error: something unexpected", piper.PullUnknownError),
+			)
+
+			It("names both causes when docker hub denies access to the repository", func() {
+				client.Command = exec.Command("sh", "-c", `echo "Error response from daemon: pull access denied for some-image, repository does not exist or may require 'docker login'" >&2; exit 1`, "--")
+
+				err := client.Pull("some-image", false)
+				Expect(err).To(MatchError("could not pull some-image: repository not found or access denied (check the image repository, and the registry credentials if it is private): Error response from daemon: pull access denied for some-image, repository does not exist or may require 'docker login'"))
+			})
+
+			It("returns the exit status when the runtime prints no error", func() {
+				client.Command = exec.Command("sh", "-c", "exit 1", "--")
+
+				err := client.Pull("some-image", false)
+				Expect(err).To(MatchError("could not pull some-image: unknown error: exit status 1"))
+			})
+
 			Context("when the executable cannot be found", func() {
 				It("returns an error", func() {
 					client = piper.DockerClient{
@@ -56,6 +204,13 @@ var _ = Describe("DockerClient", func() {
 		})
 	})
 
+	It("can run several commands with the same client", func() {
+		Expect(client.Pull("my-image", false)).To(Succeed())
+		Expect(client.Pull("my-other-image", false)).To(Succeed())
+
+		Expect(stdout.String()).To(Equal("pull my-image\npull my-other-image\n"))
+	})
+
 	Describe("Run", func() {
 		It("runs the command with the given volume mounts, and environment", func() {
 			err := client.Run([]string{"my-task.sh", "-my-arg1", "-my-arg2"}, "my-image", []piper.DockerEnv{
@@ -76,7 +231,7 @@ var _ = Describe("DockerClient", func() {
 					LocalPath:  "/some/local/path-2",
 					RemotePath: "/some/remote/path-2",
 				},
-			}, false, false, false)
+			}, piper.DockerRunOptions{}, false)
 			Expect(err).NotTo(HaveOccurred())
 
 			args := []string{
@@ -86,7 +241,6 @@ var _ = Describe("DockerClient", func() {
 				"--env=VAR2=var-2",
 				"--volume=/some/local/path-1:/some/remote/path-1",
 				"--volume=/some/local/path-2:/some/remote/path-2",
-				"--tty",
 				"my-image",
 				"my-task.sh",
 				"-my-arg1",
@@ -96,17 +250,86 @@ var _ = Describe("DockerClient", func() {
 			Expect(stdout.String()).To(Equal(strings.Join(args, " ") + "\n"))
 		})
 
+		It("runs the command in the given working directory", func() {
+			err := client.Run([]string{"my-task.sh"}, "my-image",
+				[]piper.DockerEnv{},
+				[]piper.DockerVolumeMount{}, piper.DockerRunOptions{Workdir: "/tmp/build/some-dir"}, false)
+			Expect(err).NotTo(HaveOccurred())
+
+			args := []string{
+				"run",
+				"--workdir=/tmp/build/some-dir",
+				"my-image",
+				"my-task.sh",
+			}
+
+			Expect(stdout.String()).To(Equal(strings.Join(args, " ") + "\n"))
+		})
+
+		It("runs the command as the given user", func() {
+			err := client.Run([]string{"my-task.sh"}, "my-image",
+				[]piper.DockerEnv{},
+				[]piper.DockerVolumeMount{}, piper.DockerRunOptions{User: "1000:1000"}, false)
+			Expect(err).NotTo(HaveOccurred())
+
+			args := []string{
+				"run",
+				"--workdir=/tmp/build",
+				"--user=1000:1000",
+				"my-image",
+				"my-task.sh",
+			}
+
+			Expect(stdout.String()).To(Equal(strings.Join(args, " ") + "\n"))
+		})
+
+		It("runs the command as the host user", func() {
+			err := client.Run([]string{"my-task.sh"}, "my-image",
+				[]piper.DockerEnv{},
+				[]piper.DockerVolumeMount{}, piper.DockerRunOptions{User: "task-user", HostUser: true}, false)
+			Expect(err).NotTo(HaveOccurred())
+
+			args := []string{
+				"run",
+				"--workdir=/tmp/build",
+				fmt.Sprintf("--user=%d:%d", os.Getuid(), os.Getgid()),
+				"my-image",
+				"my-task.sh",
+			}
+
+			Expect(stdout.String()).To(Equal(strings.Join(args, " ") + "\n"))
+		})
+
+		It("runs the command with the given container limits", func() {
+			err := client.Run([]string{"my-task.sh"}, "my-image",
+				[]piper.DockerEnv{},
+				[]piper.DockerVolumeMount{}, piper.DockerRunOptions{
+					Limits: piper.ContainerLimits{CPU: 512, Memory: 1024},
+				}, false)
+			Expect(err).NotTo(HaveOccurred())
+
+			args := []string{
+				"run",
+				"--workdir=/tmp/build",
+				"--cpu-shares=512",
+				"--memory=1024",
+				"my-image",
+				"my-task.sh",
+			}
+
+			Expect(stdout.String()).To(Equal(strings.Join(args, " ") + "\n"))
+		})
+
 		It("runs the command in privileged mode", func() {
 			err := client.Run([]string{"my-task.sh"}, "my-image",
 				[]piper.DockerEnv{},
-				[]piper.DockerVolumeMount{}, true, false, false)
+				[]piper.DockerVolumeMount{}, piper.DockerRunOptions{Privileged: true}, false)
 			Expect(err).NotTo(HaveOccurred())
 
 			args := []string{
 				"run",
 				"--workdir=/tmp/build",
 				"--privileged",
-				"--tty",
 				"my-image",
 				"my-task.sh",
 			}
@@ -117,14 +340,13 @@ var _ = Describe("DockerClient", func() {
 		It("runs the command with --rm argument", func() {
 			err := client.Run([]string{"my-task.sh"}, "my-image",
 				[]piper.DockerEnv{},
-				[]piper.DockerVolumeMount{}, false, false, true)
+				[]piper.DockerVolumeMount{}, piper.DockerRunOptions{Rm: true}, false)
 			Expect(err).NotTo(HaveOccurred())
 
 			args := []string{
 				"run",
 				"--workdir=/tmp/build",
 				"--rm",
-				"--tty",
 				"my-image",
 				"my-task.sh",
 			}
@@ -135,7 +357,7 @@ var _ = Describe("DockerClient", func() {
 		It("prints the docker command without running it", func() {
 			err := client.Run([]string{"my-task.sh"}, "my-image",
 				[]piper.DockerEnv{},
-				[]piper.DockerVolumeMount{}, true, true, false)
+				[]piper.DockerVolumeMount{}, piper.DockerRunOptions{Privileged: true}, true)
 			Expect(err).NotTo(HaveOccurred())
 
 			args := []string{
@@ -143,7 +365,6 @@ var _ = Describe("DockerClient", func() {
 				"run",
 				"--workdir=/tmp/build",
 				"--privileged",
-				"--tty",
 				"my-image",
 				"my-task.sh",
 			}
@@ -151,17 +372,302 @@ var _ = Describe("DockerClient", func() {
 			Expect(stdout.String()).To(Equal(strings.Join(args, " ") + "\n"))
 		})
 
+		It("masks secret env values when printing the docker command", func() {
+			err := client.Run([]string{"my-task.sh"}, "my-image",
+				[]piper.DockerEnv{
+					{Key: "VAR1", Value: "var-1"},
+					{Key: "TOKEN", Value: "some-token", Secret: true},
+				},
+				[]piper.DockerVolumeMount{}, piper.DockerRunOptions{}, true)
+			Expect(err).NotTo(HaveOccurred())
+
+			args := []string{
+				"echo",
+				"run",
+				"--workdir=/tmp/build",
+				"--env=VAR1=var-1",
+				"--env=TOKEN=[redacted]",
+				"my-image",
+				"my-task.sh",
+			}
+
+			Expect(stdout.String()).To(Equal(strings.Join(args, " ") + "\n"))
+		})
+
+		It("passes secret env values to the container", func() {
+			err := client.Run([]string{"my-task.sh"}, "my-image",
+				[]piper.DockerEnv{
+					{Key: "TOKEN", Value: "some-token", Secret: true},
+				},
+				[]piper.DockerVolumeMount{}, piper.DockerRunOptions{}, false)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(stdout.String()).To(ContainSubstring("--env=TOKEN=some-token"))
+		})
+
+		Context("when the env is passed in an env file", func() {
+			var envVars []piper.DockerEnv
+
+			BeforeEach(func() {
+				envVars = []piper.DockerEnv{
+					{Key: "VAR1", Value: "var-1"},
+					{Key: "TOKEN", Value: "some-token", Secret: true},
+				}
+			})
+
+			It("writes the env to a private file that is removed afterwards", func() {
+				client.Command = exec.Command("sh", "-c", `
+					for arg; do
+						case "$arg" in
+							--env-file=*)
+								path="${arg#--env-file=}"
+								echo "$path"
+								stat -c %a "$path"
+								cat "$path"
+								;;
+						esac
+					done`, "--")
+
+				err := client.Run([]string{"my-task.sh"}, "my-image", envVars, []piper.DockerVolumeMount{}, piper.DockerRunOptions{
+					EnvFile: true,
+				}, false)
+				Expect(err).NotTo(HaveOccurred())
+
+				lines := strings.Split(stdout.String(), "\n")
+				Expect(lines).To(HaveLen(5))
+				Expect(lines[1:]).To(Equal([]string{"600", "VAR1=var-1", "TOKEN=some-token", ""}))
+				Expect(lines[0]).NotTo(BeEmpty())
+				Expect(lines[0]).NotTo(BeAnExistingFile())
+			})
+
+			It("prints the redacted contents of the env file in dry-run", func() {
+				err := client.Run([]string{"my-task.sh"}, "my-image", envVars, []piper.DockerVolumeMount{}, piper.DockerRunOptions{
+					EnvFile: true,
+				}, true)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(stdout.String()).To(Equal(strings.Join([]string{
+					"echo run --workdir=/tmp/build --env-file=<env-file> my-image my-task.sh",
+					"# <env-file>",
+					"# VAR1=var-1",
+					"# TOKEN=[redacted]",
+				}, "\n") + "\n"))
+			})
+
+			It("returns an error when a value contains a newline", func() {
+				envVars = append(envVars, piper.DockerEnv{Key: "CERT", Value: "line-1\nline-2"})
+
+				err := client.Run([]string{"my-task.sh"}, "my-image", envVars, []piper.DockerVolumeMount{}, piper.DockerRunOptions{
+					EnvFile: true,
+				}, false)
+				Expect(err).To(MatchError(`cannot pass env var "CERT" in an env file: its value contains a newline`))
+			})
+		})
+
+		It("allocates a tty for the task", func() {
+			err := client.Run([]string{"my-task.sh"}, "my-image", nil, nil, piper.DockerRunOptions{Tty: true}, false)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(stdout.String()).To(Equal("run --workdir=/tmp/build --tty my-image my-task.sh\n"))
+		})
+
+		It("passes stdin through to an interactive task", func() {
+			client.Command = exec.Command("sh", "-c", `echo "$@" && cat`, "--")
+			client.Stdin = strings.NewReader("some-input\n")
+
+			err := client.Run([]string{"my-task.sh"}, "my-image", nil, nil, piper.DockerRunOptions{
+				Interactive: true,
+				Tty:         true,
+			}, false)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(stdout.String()).To(Equal("run --workdir=/tmp/build --interactive --tty my-image my-task.sh\nsome-input\n"))
+		})
+
+		It("names the container", func() {
+			err := client.Run([]string{"my-task.sh"}, "my-image", nil, nil, piper.DockerRunOptions{Name: "piper-some-name"}, false)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(stdout.String()).To(Equal("run --workdir=/tmp/build --name=piper-some-name my-image my-task.sh\n"))
+		})
+
+		Context("when the container is kept on failure", func() {
+			var (
+				tempDir string
+				logPath string
+				options piper.DockerRunOptions
+			)
+
+			BeforeEach(func() {
+				var err error
+				tempDir, err = ioutil.TempDir("", "")
+				Expect(err).NotTo(HaveOccurred())
+
+				logPath = filepath.Join(tempDir, "invocations")
+				client.Command = exec.Command("sh", "-c", `
+					echo "$@" >> "$0"
+					if [ "$1" = run ]; then
+						exit "${EXIT_STATUS:-0}"
+					fi`, logPath)
+
+				options = piper.DockerRunOptions{
+					Name:          "piper-some-name",
+					Rm:            true,
+					KeepOnFailure: true,
+				}
+			})
+
+			AfterEach(func() {
+				Expect(os.RemoveAll(tempDir)).To(Succeed())
+			})
+
+			It("removes the container once the task succeeds", func() {
+				err := client.Run([]string{"my-task.sh"}, "my-image", nil, nil, options, false)
+				Expect(err).NotTo(HaveOccurred())
+
+				invocations, err := ioutil.ReadFile(logPath)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(string(invocations)).To(Equal(`run --workdir=/tmp/build --name=piper-some-name my-image my-task.sh
+rm --force piper-some-name
+`))
+			})
+
+			It("keeps the container when the task fails", func() {
+				os.Setenv("EXIT_STATUS", "3")
+				defer os.Unsetenv("EXIT_STATUS")
+
+				err := client.Run([]string{"my-task.sh"}, "my-image", nil, nil, options, false)
+				Expect(err).To(Equal(piper.ContainerExitError{Status: 3}))
+
+				invocations, err := ioutil.ReadFile(logPath)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(string(invocations)).To(Equal("run --workdir=/tmp/build --name=piper-some-name my-image my-task.sh\n"))
+			})
+
+			It("removes the container with --rm when it is not named", func() {
+				options.Name = ""
+
+				err := client.Run([]string{"my-task.sh"}, "my-image", nil, nil, options, false)
+				Expect(err).NotTo(HaveOccurred())
+
+				invocations, err := ioutil.ReadFile(logPath)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(string(invocations)).To(Equal("run --workdir=/tmp/build --rm my-image my-task.sh\n"))
+			})
+		})
+
+		Context("when the context is cancelled while the container runs", func() {
+			var (
+				tempDir string
+				logPath string
+			)
+
+			BeforeEach(func() {
+				var err error
+				tempDir, err = ioutil.TempDir("", "")
+				Expect(err).NotTo(HaveOccurred())
+
+				logPath = filepath.Join(tempDir, "invocations")
+				client.Command = exec.Command("sh", "-c", `
+					echo "$@" >> "$0"
+					if [ "$1" = run ]; then
+						exec sleep 60
+					fi
+					if [ "$1" = stop ] && [ -n "$FAIL_STOP" ]; then
+						exit 1
+					fi`, logPath)
+			})
+
+			AfterEach(func() {
+				Expect(os.RemoveAll(tempDir)).To(Succeed())
+			})
+
+			runAndCancel := func(options piper.DockerRunOptions) error {
+				ctx, cancel := context.WithCancel(context.Background())
+				go func() {
+					defer GinkgoRecover()
+
+					Eventually(func() string {
+						invocations, _ := ioutil.ReadFile(logPath)
+						return string(invocations)
+					}).Should(HavePrefix("run "))
+					cancel()
+				}()
+
+				return client.RunContext(ctx, []string{"my-task.sh"}, "my-image", nil, nil, options, false)
+			}
+
+			It("stops and removes the container", func() {
+				err := runAndCancel(piper.DockerRunOptions{
+					Name:            "piper-some-name",
+					Rm:              true,
+					StopGracePeriod: 5 * time.Second,
+				})
+				Expect(err).To(Equal(context.Canceled))
+
+				invocations, err := ioutil.ReadFile(logPath)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(string(invocations)).To(Equal(`run --workdir=/tmp/build --name=piper-some-name --rm my-image my-task.sh
+stop --time=5 piper-some-name
+rm --force piper-some-name
+`))
+			})
+
+			It("kills the container when it cannot be stopped", func() {
+				os.Setenv("FAIL_STOP", "true")
+				defer os.Unsetenv("FAIL_STOP")
+
+				err := runAndCancel(piper.DockerRunOptions{Name: "piper-some-name"})
+				Expect(err).To(Equal(context.Canceled))
+
+				invocations, err := ioutil.ReadFile(logPath)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(string(invocations)).To(Equal(`run --workdir=/tmp/build --name=piper-some-name my-image my-task.sh
+stop --time=10 piper-some-name
+kill piper-some-name
+`))
+			})
+		})
+
 		Context("failure cases", func() {
+			It("returns the exit status of the task", func() {
+				client.Command = exec.Command("sh", "-c", "exit 3", "--")
+
+				err := client.Run([]string{"my-task.sh"}, "my-image", nil, nil, piper.DockerRunOptions{}, false)
+				Expect(err).To(Equal(piper.ContainerExitError{Status: 3}))
+				Expect(err).To(MatchError("task exited with status 3"))
+			})
+
+			It("returns the error of the runtime when it fails to run the container", func() {
+				client.Command = exec.Command("sh", "-c", "exit 125", "--")
+
+				err := client.Run([]string{"my-task.sh"}, "my-image", nil, nil, piper.DockerRunOptions{}, false)
+				Expect(err).To(MatchError("exit status 125"))
+				Expect(err).NotTo(BeAssignableToTypeOf(piper.ContainerExitError{}))
+			})
+
 			Context("when the executable cannot be found", func() {
 				It("returns an error", func() {
 					client = piper.DockerClient{
 						Command: exec.Command("no-such-executable"),
 						Stdout:  stdout,
 					}
-					err := client.Run([]string{"some-command"}, "some-image", []piper.DockerEnv{}, []piper.DockerVolumeMount{}, false, false, false)
+					err := client.Run([]string{"some-command"}, "some-image", []piper.DockerEnv{}, []piper.DockerVolumeMount{}, piper.DockerRunOptions{}, false)
 					Expect(err).To(MatchError(ContainSubstring("executable file not found in $PATH")))
 				})
 			})
 		})
 	})
+
+	Describe("NewContainerName", func() {
+		It("generates a random piper container name", func() {
+			name, err := piper.NewContainerName()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(name).To(MatchRegexp(`^piper-[0-9a-f]{12}$`))
+
+			otherName, err := piper.NewContainerName()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(otherName).NotTo(Equal(name))
+		})
+	})
 })
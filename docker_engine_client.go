@@ -0,0 +1,581 @@
+package piper
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultDockerHost      = "unix:///var/run/docker.sock"
+	dockerEngineAPIVersion = "v1.41"
+)
+
+// DockerEngineError is an error response from the Docker Engine API.
+type DockerEngineError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e DockerEngineError) Error() string {
+	return fmt.Sprintf("docker engine responded with status %d: %s", e.StatusCode, e.Message)
+}
+
+// DockerEngineClient talks to the Docker Engine HTTP API instead of running
+// the docker CLI, so that it can report container IDs, exit codes and the
+// errors returned by the daemon.
+type DockerEngineClient struct {
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+
+	baseURL string
+	client  *http.Client
+	auths   map[string]DockerRegistryAuth
+}
+
+// NewDockerEngineClient connects to the daemon at host, which takes the form
+// of $DOCKER_HOST: unix:///path/to/docker.sock or tcp://host:port. An empty
+// host connects to DefaultDockerHost.
+func NewDockerEngineClient(host string, stdin io.Reader, stdout, stderr io.Writer) (DockerEngineClient, error) {
+	if host == "" {
+		host = DefaultDockerHost
+	}
+
+	hostURL, err := url.Parse(host)
+	if err != nil {
+		return DockerEngineClient{}, fmt.Errorf("could not parse docker host %q: %s", host, err)
+	}
+
+	client := DockerEngineClient{
+		Stdin:  stdin,
+		Stdout: stdout,
+		Stderr: stderr,
+		client: &http.Client{},
+		auths:  make(map[string]DockerRegistryAuth),
+	}
+
+	switch hostURL.Scheme {
+	case "unix":
+		socket := hostURL.Path
+		client.baseURL = "http://docker"
+		client.client.Transport = &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var dialer net.Dialer
+				return dialer.DialContext(ctx, "unix", socket)
+			},
+		}
+	case "tcp", "http":
+		client.baseURL = fmt.Sprintf("http://%s", hostURL.Host)
+	default:
+		return DockerEngineClient{}, fmt.Errorf("unsupported docker host %q: must be a unix:// or tcp:// address", host)
+	}
+
+	return client, nil
+}
+
+// Login checks the credentials with the daemon and keeps them to
+// authenticate later pulls from the registry.
+func (c DockerEngineClient) Login(auth DockerRegistryAuth, dryRun bool) error {
+	if dryRun {
+		fmt.Fprintf(c.Stdout, "POST /%s/auth serveraddress=%s username=%s\n", dockerEngineAPIVersion, auth.Registry, auth.Username)
+		return nil
+	}
+
+	response, err := c.do(context.Background(), http.MethodPost, "/auth", nil, registryAuthConfig(auth), nil)
+	if err != nil {
+		return err
+	}
+	response.Body.Close()
+
+	c.auths[auth.Registry] = auth
+	return nil
+}
+
+func registryAuthConfig(auth DockerRegistryAuth) map[string]string {
+	return map[string]string{
+		"username":      auth.Username,
+		"password":      auth.Password,
+		"serveraddress": auth.Registry,
+	}
+}
+
+// ImageExists reports whether the image is present locally. It is looked for
+// even in dry-run, as that changes nothing.
+func (c DockerEngineClient) ImageExists(image string) (bool, error) {
+	response, err := c.do(context.Background(), http.MethodGet, path.Join("/images", image, "json"), nil, nil, nil)
+	if engineError, ok := err.(DockerEngineError); ok && engineError.StatusCode == http.StatusNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return true, response.Body.Close()
+}
+
+// Pull pulls the image, writing its progress to Stdout. Errors reported by the
+// daemon are returned as a PullError.
+func (c DockerEngineClient) Pull(image string, dryRun bool) error {
+	name, tag := splitImageReference(image)
+	query := url.Values{"fromImage": {name}, "tag": {tag}}
+
+	if dryRun {
+		fmt.Fprintf(c.Stdout, "POST /%s/images/create?%s\n", dockerEngineAPIVersion, query.Encode())
+		return nil
+	}
+
+	header := http.Header{}
+	if auth, ok := c.auths[registryHost(name)]; ok {
+		encoded, err := json.Marshal(registryAuthConfig(auth))
+		if err != nil {
+			return err
+		}
+		header.Set("X-Registry-Auth", base64.URLEncoding.EncodeToString(encoded))
+	}
+
+	response, err := c.do(context.Background(), http.MethodPost, "/images/create", query, nil, header)
+	if engineError, ok := err.(DockerEngineError); ok {
+		return classifyPullError(image, engineError.Message)
+	}
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	decoder := json.NewDecoder(response.Body)
+	for {
+		var message struct {
+			ID       string `json:"id"`
+			Status   string `json:"status"`
+			Progress string `json:"progress"`
+			Error    string `json:"error"`
+		}
+
+		err := decoder.Decode(&message)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("could not read pull progress of %s: %s", image, err)
+		}
+
+		if message.Error != "" {
+			return classifyPullError(image, message.Error)
+		}
+
+		line := message.Status
+		if message.ID != "" {
+			line = fmt.Sprintf("%s: %s", message.ID, line)
+		}
+		if message.Progress != "" {
+			line = fmt.Sprintf("%s %s", line, message.Progress)
+		}
+		fmt.Fprintln(c.Stdout, line)
+	}
+}
+
+// Run runs the task container like RunContext, without a way to cancel it.
+func (c DockerEngineClient) Run(
+	command []string,
+	image string,
+	envVars []DockerEnv,
+	mounts []DockerVolumeMount,
+	options DockerRunOptions,
+	dryRun bool,
+) error {
+	return c.RunContext(context.Background(), command, image, envVars, mounts, options, dryRun)
+}
+
+// RunContext creates and starts the task container, streams its output to
+// Stdout and Stderr, and waits for it to exit. A non-zero exit status is
+// returned as a ContainerExitError. When ctx is done, the container is
+// stopped, killed if it does not stop within the grace period, and ctx.Err()
+// is returned.
+func (c DockerEngineClient) RunContext(
+	ctx context.Context,
+	command []string,
+	image string,
+	envVars []DockerEnv,
+	mounts []DockerVolumeMount,
+	options DockerRunOptions,
+	dryRun bool,
+) (err error) {
+	config := c.containerConfig(command, image, envVars, mounts, options)
+
+	if dryRun {
+		for i, envVar := range envVars {
+			config.Env[i] = envVar.envFileLine(true)
+		}
+
+		encoded, err := json.Marshal(config)
+		if err != nil {
+			return err
+		}
+
+		endpoint := "containers/create"
+		if options.Name != "" {
+			endpoint = fmt.Sprintf("%s?%s", endpoint, url.Values{"name": {options.Name}}.Encode())
+		}
+
+		fmt.Fprintf(c.Stdout, "POST /%s/%s %s\n", dockerEngineAPIVersion, endpoint, encoded)
+		return nil
+	}
+
+	id, err := c.createContainer(config, options.Name)
+	if err != nil {
+		return err
+	}
+
+	if options.Rm {
+		defer func() {
+			if err == nil || ctx.Err() != nil || !options.KeepOnFailure {
+				c.RemoveContainer(id)
+			}
+		}()
+	}
+
+	if options.Interactive {
+		stdin, err := c.attachStdin(ctx, id)
+		if err != nil {
+			return err
+		}
+		defer stdin.Close()
+
+		go func() {
+			io.Copy(stdin, c.Stdin)
+			stdin.Close()
+		}()
+	}
+
+	err = c.StartContainer(id)
+	if err != nil {
+		return err
+	}
+
+	err = c.streamLogs(ctx, id, config.Tty)
+	if ctx.Err() != nil {
+		err = c.StopContainer(id, options.stopGracePeriod())
+		if err != nil {
+			c.KillContainer(id)
+		}
+
+		return ctx.Err()
+	}
+	if err != nil {
+		return err
+	}
+
+	status, err := c.WaitContainer(id)
+	if err != nil {
+		return err
+	}
+
+	if status != 0 {
+		return ContainerExitError{Status: status, ContainerID: id}
+	}
+
+	return nil
+}
+
+// ContainerConfig is the body of a create container request.
+type ContainerConfig struct {
+	Image      string
+	Cmd        []string
+	Env        []string
+	WorkingDir string
+	User       string `json:",omitempty"`
+	Tty        bool
+	OpenStdin  bool `json:",omitempty"`
+	StdinOnce  bool `json:",omitempty"`
+	HostConfig ContainerHostConfig
+}
+
+type ContainerHostConfig struct {
+	Binds      []string
+	Privileged bool
+	CPUShares  uint64 `json:"CpuShares,omitempty"`
+	Memory     uint64 `json:",omitempty"`
+}
+
+func (c DockerEngineClient) containerConfig(command []string, image string, envVars []DockerEnv, mounts []DockerVolumeMount, options DockerRunOptions) ContainerConfig {
+	workdir := options.Workdir
+	if workdir == "" {
+		workdir = VolumeMountPoint
+	}
+
+	config := ContainerConfig{
+		Image:      image,
+		Cmd:        command,
+		Env:        []string{},
+		WorkingDir: workdir,
+		User:       options.user(),
+		Tty:        options.Tty,
+		OpenStdin:  options.Interactive,
+		StdinOnce:  options.Interactive,
+		HostConfig: ContainerHostConfig{
+			Binds:      []string{},
+			Privileged: options.Privileged,
+			CPUShares:  options.Limits.CPU,
+			Memory:     uint64(options.Limits.Memory),
+		},
+	}
+
+	for _, envVar := range envVars {
+		config.Env = append(config.Env, envVar.envFileLine(false))
+	}
+
+	for _, mount := range mounts {
+		config.HostConfig.Binds = append(config.HostConfig.Binds, fmt.Sprintf("%s:%s", mount.LocalPath, mount.RemotePath))
+	}
+
+	return config
+}
+
+// CreateContainer creates a container and returns its ID.
+func (c DockerEngineClient) CreateContainer(config ContainerConfig) (string, error) {
+	return c.createContainer(config, "")
+}
+
+func (c DockerEngineClient) createContainer(config ContainerConfig, name string) (string, error) {
+	var query url.Values
+	if name != "" {
+		query = url.Values{"name": {name}}
+	}
+
+	response, err := c.do(context.Background(), http.MethodPost, "/containers/create", query, config, nil)
+	if err != nil {
+		return "", err
+	}
+	defer response.Body.Close()
+
+	var created struct {
+		ID       string `json:"Id"`
+		Warnings []string
+	}
+	err = json.NewDecoder(response.Body).Decode(&created)
+	if err != nil {
+		return "", fmt.Errorf("could not read created container: %s", err)
+	}
+
+	for _, warning := range created.Warnings {
+		fmt.Fprintf(c.Stderr, "warning: %s\n", warning)
+	}
+
+	return created.ID, nil
+}
+
+func (c DockerEngineClient) StartContainer(id string) error {
+	response, err := c.do(context.Background(), http.MethodPost, path.Join("/containers", id, "start"), nil, nil, nil)
+	if err != nil {
+		return err
+	}
+
+	return response.Body.Close()
+}
+
+// attachStdin attaches to the stdin of a container, which the daemon hijacks
+// the connection for. The stdin of the container is closed when the returned
+// stream is closed.
+func (c DockerEngineClient) attachStdin(ctx context.Context, id string) (io.WriteCloser, error) {
+	query := url.Values{"stream": {"1"}, "stdin": {"1"}}
+	header := http.Header{"Connection": {"Upgrade"}, "Upgrade": {"tcp"}}
+
+	response, err := c.do(ctx, http.MethodPost, path.Join("/containers", id, "attach"), query, nil, header)
+	if err != nil {
+		return nil, err
+	}
+
+	stream, ok := response.Body.(io.WriteCloser)
+	if !ok || response.StatusCode != http.StatusSwitchingProtocols {
+		response.Body.Close()
+		return nil, fmt.Errorf("could not attach to the stdin of container %s: the connection was not upgraded", id)
+	}
+
+	return stream, nil
+}
+
+// StreamLogs follows the output of a container until it exits. Without a tty
+// the daemon multiplexes stdout and stderr into a single stream.
+func (c DockerEngineClient) StreamLogs(id string, tty bool) error {
+	return c.streamLogs(context.Background(), id, tty)
+}
+
+func (c DockerEngineClient) streamLogs(ctx context.Context, id string, tty bool) error {
+	query := url.Values{"follow": {"1"}, "stdout": {"1"}, "stderr": {"1"}}
+
+	response, err := c.do(ctx, http.MethodGet, path.Join("/containers", id, "logs"), query, nil, nil)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if tty {
+		_, err = io.Copy(c.Stdout, response.Body)
+		return err
+	}
+
+	return demultiplexLogs(response.Body, c.Stdout, c.Stderr)
+}
+
+// WaitContainer waits for a container to exit and returns its exit status.
+func (c DockerEngineClient) WaitContainer(id string) (int, error) {
+	response, err := c.do(context.Background(), http.MethodPost, path.Join("/containers", id, "wait"), nil, nil, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer response.Body.Close()
+
+	var result struct {
+		StatusCode int
+		Error      *struct {
+			Message string
+		}
+	}
+	err = json.NewDecoder(response.Body).Decode(&result)
+	if err != nil {
+		return 0, fmt.Errorf("could not read exit status of container %s: %s", id, err)
+	}
+
+	if result.Error != nil && result.Error.Message != "" {
+		return 0, fmt.Errorf("could not wait for container %s: %s", id, result.Error.Message)
+	}
+
+	return result.StatusCode, nil
+}
+
+// StopContainer stops a container, killing it if it does not exit within the
+// grace period.
+func (c DockerEngineClient) StopContainer(id string, gracePeriod time.Duration) error {
+	query := url.Values{"t": {fmt.Sprint(int(gracePeriod.Round(time.Second) / time.Second))}}
+
+	response, err := c.do(context.Background(), http.MethodPost, path.Join("/containers", id, "stop"), query, nil, nil)
+	if err != nil {
+		return err
+	}
+
+	return response.Body.Close()
+}
+
+func (c DockerEngineClient) KillContainer(id string) error {
+	response, err := c.do(context.Background(), http.MethodPost, path.Join("/containers", id, "kill"), nil, nil, nil)
+	if err != nil {
+		return err
+	}
+
+	return response.Body.Close()
+}
+
+// RemoveContainer removes a container along with its anonymous volumes.
+func (c DockerEngineClient) RemoveContainer(id string) error {
+	query := url.Values{"v": {"1"}, "force": {"1"}}
+
+	response, err := c.do(context.Background(), http.MethodDelete, path.Join("/containers", id), query, nil, nil)
+	if err != nil {
+		return err
+	}
+
+	return response.Body.Close()
+}
+
+func (c DockerEngineClient) do(ctx context.Context, method, endpoint string, query url.Values, body interface{}, header http.Header) (*http.Response, error) {
+	var requestBody io.Reader
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		requestBody = bytes.NewReader(encoded)
+	}
+
+	requestURL := fmt.Sprintf("%s/%s%s", c.baseURL, dockerEngineAPIVersion, endpoint)
+	if len(query) > 0 {
+		requestURL = fmt.Sprintf("%s?%s", requestURL, query.Encode())
+	}
+
+	request, err := http.NewRequestWithContext(ctx, method, requestURL, requestBody)
+	if err != nil {
+		return nil, err
+	}
+
+	for key, values := range header {
+		request.Header[key] = values
+	}
+	if body != nil {
+		request.Header.Set("Content-Type", "application/json")
+	}
+
+	response, err := c.client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+
+	if response.StatusCode >= 400 {
+		defer response.Body.Close()
+
+		contents, _ := io.ReadAll(response.Body)
+
+		var message struct {
+			Message string `json:"message"`
+		}
+		if json.Unmarshal(contents, &message) != nil || message.Message == "" {
+			message.Message = strings.TrimSpace(string(contents))
+		}
+
+		return nil, DockerEngineError{StatusCode: response.StatusCode, Message: message.Message}
+	}
+
+	return response, nil
+}
+
+// demultiplexLogs splits a multiplexed log stream, in which each frame starts
+// with a header holding the stream type and the length of the frame.
+func demultiplexLogs(stream io.Reader, stdout, stderr io.Writer) error {
+	reader := bufio.NewReader(stream)
+	header := make([]byte, 8)
+
+	for {
+		_, err := io.ReadFull(reader, header)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		output := stdout
+		if header[0] == 2 {
+			output = stderr
+		}
+
+		_, err = io.CopyN(output, reader, int64(binary.BigEndian.Uint32(header[4:])))
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// splitImageReference splits an image reference into the name and the tag
+// or digest that the images/create endpoint expects, defaulting to latest.
+func splitImageReference(image string) (string, string) {
+	if i := strings.LastIndex(image, "@"); i != -1 {
+		return image[:i], image[i+1:]
+	}
+
+	if i := strings.LastIndex(image, ":"); i > strings.LastIndex(image, "/") {
+		return image[:i], image[i+1:]
+	}
+
+	return image, "latest"
+}
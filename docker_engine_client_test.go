@@ -0,0 +1,521 @@
+package piper_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ryanmoran/piper"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type fakeDockerEngine struct {
+	sync.Mutex
+
+	Requests      []string
+	Auth          map[string]string
+	RegistryAuth  string
+	Config        map[string]interface{}
+	PullMessages  []string
+	Logs          []byte
+	StatusCode    int
+	CreateFailure string
+	PullFailure   string
+	BlockLogs     bool
+	Stdin         string
+	Images        []string
+
+	attached  bool
+	stdinRead chan struct{}
+}
+
+func (e *fakeDockerEngine) stdin() string {
+	e.Lock()
+	defer e.Unlock()
+
+	return e.Stdin
+}
+
+func (e *fakeDockerEngine) requests() []string {
+	e.Lock()
+	defer e.Unlock()
+
+	return append([]string{}, e.Requests...)
+}
+
+// methodMux routes requests by method and path, matching patterns that end in
+// a slash by prefix.
+type methodMux map[string]http.HandlerFunc
+
+func (m methodMux) HandleFunc(pattern string, handler http.HandlerFunc) {
+	m[pattern] = handler
+}
+
+func (m methodMux) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	route := fmt.Sprintf("%s %s", r.Method, r.URL.Path)
+	if handler, ok := m[route]; ok {
+		handler(w, r)
+		return
+	}
+
+	for pattern, handler := range m {
+		if strings.HasSuffix(pattern, "/") && strings.HasPrefix(route, pattern) {
+			handler(w, r)
+			return
+		}
+	}
+
+	http.NotFound(w, r)
+}
+
+func (e *fakeDockerEngine) Handler() http.Handler {
+	mux := methodMux{}
+	e.stdinRead = make(chan struct{})
+
+	mux.HandleFunc("POST /v1.41/auth", func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&e.Auth)
+		fmt.Fprint(w, `{"Status":"Login Succeeded"}`)
+	})
+
+	mux.HandleFunc("POST /v1.41/images/create", func(w http.ResponseWriter, r *http.Request) {
+		e.RegistryAuth = r.Header.Get("X-Registry-Auth")
+		if e.PullFailure != "" {
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprintf(w, `{"message":%q}`, e.PullFailure)
+			return
+		}
+
+		for _, message := range e.PullMessages {
+			fmt.Fprintln(w, message)
+		}
+	})
+
+	mux.HandleFunc("GET /v1.41/images/", func(w http.ResponseWriter, r *http.Request) {
+		image := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/v1.41/images/"), "/json")
+		for _, present := range e.Images {
+			if image == present {
+				fmt.Fprintf(w, `{"Id":"sha256:some-image-id","RepoTags":[%q]}`, image)
+				return
+			}
+		}
+
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(w, `{"message":"No such image: %s"}`, image)
+	})
+
+	mux.HandleFunc("POST /v1.41/containers/create", func(w http.ResponseWriter, r *http.Request) {
+		if e.CreateFailure != "" {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprintf(w, `{"message":%q}`, e.CreateFailure)
+			return
+		}
+
+		json.NewDecoder(r.Body).Decode(&e.Config)
+		fmt.Fprint(w, `{"Id":"some-container-id","Warnings":["some-warning"]}`)
+	})
+
+	mux.HandleFunc("POST /v1.41/containers/some-container-id/start", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	mux.HandleFunc("POST /v1.41/containers/some-container-id/attach", func(w http.ResponseWriter, r *http.Request) {
+		conn, buffered, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		e.Lock()
+		e.attached = true
+		e.Unlock()
+
+		fmt.Fprint(conn, "HTTP/1.1 101 UPGRADED\r\nConnection: Upgrade\r\nUpgrade: tcp\r\n\r\n")
+
+		stdin, _ := ioutil.ReadAll(buffered)
+
+		e.Lock()
+		e.Stdin = string(stdin)
+		e.Unlock()
+		close(e.stdinRead)
+	})
+
+	mux.HandleFunc("GET /v1.41/containers/some-container-id/logs", func(w http.ResponseWriter, r *http.Request) {
+		e.Lock()
+		attached := e.attached
+		e.Unlock()
+		if attached {
+			<-e.stdinRead
+		}
+
+		w.Write(e.Logs)
+		if e.BlockLogs {
+			w.(http.Flusher).Flush()
+			<-r.Context().Done()
+		}
+	})
+
+	mux.HandleFunc("POST /v1.41/containers/some-container-id/stop", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	mux.HandleFunc("POST /v1.41/containers/some-container-id/wait", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"StatusCode":%d}`, e.StatusCode)
+	})
+
+	mux.HandleFunc("DELETE /v1.41/containers/some-container-id", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		e.Lock()
+		e.Requests = append(e.Requests, fmt.Sprintf("%s %s", r.Method, r.URL.RequestURI()))
+		e.Unlock()
+
+		mux.ServeHTTP(w, r)
+	})
+}
+
+var _ = Describe("DockerEngineClient", func() {
+	var (
+		engine  *fakeDockerEngine
+		server  *httptest.Server
+		tempDir string
+		client  piper.DockerEngineClient
+		stdout  *bytes.Buffer
+		stderr  *bytes.Buffer
+	)
+
+	BeforeEach(func() {
+		var err error
+		tempDir, err = ioutil.TempDir("", "")
+		Expect(err).NotTo(HaveOccurred())
+
+		socket := filepath.Join(tempDir, "docker.sock")
+		listener, err := net.Listen("unix", socket)
+		Expect(err).NotTo(HaveOccurred())
+
+		engine = &fakeDockerEngine{}
+		server = httptest.NewUnstartedServer(engine.Handler())
+		server.Listener = listener
+		server.Start()
+
+		stdout = bytes.NewBuffer([]byte{})
+		stderr = bytes.NewBuffer([]byte{})
+
+		client, err = piper.NewDockerEngineClient("unix://"+socket, nil, stdout, stderr)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		server.Close()
+		Expect(os.RemoveAll(tempDir)).To(Succeed())
+	})
+
+	Describe("NewDockerEngineClient", func() {
+		It("connects to a tcp docker host", func() {
+			tcpServer := httptest.NewServer(engine.Handler())
+			defer tcpServer.Close()
+
+			tcpClient, err := piper.NewDockerEngineClient(strings.Replace(tcpServer.URL, "http://", "tcp://", 1), nil, stdout, stderr)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(tcpClient.StartContainer("some-container-id")).To(Succeed())
+			Expect(engine.Requests).To(Equal([]string{"POST /v1.41/containers/some-container-id/start"}))
+		})
+
+		It("returns an error for an unsupported docker host", func() {
+			_, err := piper.NewDockerEngineClient("npipe:////./pipe/docker_engine", nil, stdout, stderr)
+			Expect(err).To(MatchError(`unsupported docker host "npipe:////./pipe/docker_engine": must be a unix:// or tcp:// address`))
+		})
+	})
+
+	Describe("ImageExists", func() {
+		It("inspects the image", func() {
+			engine.Images = []string{"my-org/my-image:1.0"}
+
+			exists, err := client.ImageExists("my-org/my-image:1.0")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(exists).To(BeTrue())
+
+			exists, err = client.ImageExists("my-other-image")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(exists).To(BeFalse())
+
+			Expect(engine.requests()).To(Equal([]string{
+				"GET /v1.41/images/my-org/my-image:1.0/json",
+				"GET /v1.41/images/my-other-image/json",
+			}))
+		})
+	})
+
+	Describe("Login and Pull", func() {
+		It("pulls the image with the credentials given to login, printing the progress", func() {
+			engine.PullMessages = []string{
+				`{"status":"Pulling from library/my-image","id":"1.0"}`,
+				`{"status":"Downloading","progressDetail":{"current":1,"total":2},"progress":"[=====>     ]","id":"abc123"}`,
+				`{"status":"Status: Downloaded newer image for registry.example.com/my-image:1.0"}`,
+			}
+
+			err := client.Login(piper.DockerRegistryAuth{
+				Registry: "registry.example.com",
+				Username: "some-user",
+				Password: "some-password",
+			}, false)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(engine.Auth).To(Equal(map[string]string{
+				"username":      "some-user",
+				"password":      "some-password",
+				"serveraddress": "registry.example.com",
+			}))
+
+			err = client.Pull("registry.example.com/my-image:1.0", false)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(engine.Requests).To(Equal([]string{
+				"POST /v1.41/auth",
+				"POST /v1.41/images/create?fromImage=registry.example.com%2Fmy-image&tag=1.0",
+			}))
+
+			registryAuth, err := base64.URLEncoding.DecodeString(engine.RegistryAuth)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(registryAuth).To(MatchJSON(`{"username":"some-user","password":"some-password","serveraddress":"registry.example.com"}`))
+
+			Expect(stdout.String()).To(Equal(`1.0: Pulling from library/my-image
+abc123: Downloading [=====>     ]
+Status: Downloaded newer image for registry.example.com/my-image:1.0
+`))
+		})
+
+		It("pulls the latest tag or a digest", func() {
+			Expect(client.Pull("my-image", false)).To(Succeed())
+			Expect(client.Pull("localhost:5000/my-image@sha256:abc", false)).To(Succeed())
+
+			Expect(engine.Requests).To(Equal([]string{
+				"POST /v1.41/images/create?fromImage=my-image&tag=latest",
+				"POST /v1.41/images/create?fromImage=localhost%3A5000%2Fmy-image&tag=sha256%3Aabc",
+			}))
+			Expect(engine.RegistryAuth).To(BeEmpty())
+		})
+
+		It("prints the requests in dry-run", func() {
+			Expect(client.Login(piper.DockerRegistryAuth{Username: "some-user", Password: "some-password"}, true)).To(Succeed())
+			Expect(client.Pull("my-image:1.0", true)).To(Succeed())
+
+			Expect(engine.Requests).To(BeEmpty())
+			Expect(stdout.String()).To(Equal(`POST /v1.41/auth serveraddress= username=some-user
+POST /v1.41/images/create?fromImage=my-image&tag=1.0
+`))
+		})
+
+		Context("failure cases", func() {
+			It("returns the error reported in the pull progress", func() {
+				engine.PullMessages = []string{
+					`{"status":"Pulling from library/my-image","id":"1.0"}`,
+					`{"errorDetail":{"message":"manifest unknown"},"error":"manifest unknown"}`,
+				}
+
+				err := client.Pull("my-image:1.0", false)
+				Expect(err).To(Equal(piper.PullError{Image: "my-image:1.0", Kind: piper.PullNotFound, Message: "manifest unknown"}))
+				Expect(err).To(MatchError("could not pull my-image:1.0: manifest not found (check the image repository and tag): manifest unknown"))
+			})
+
+			It("classifies the error returned by the daemon", func() {
+				engine.PullFailure = `Get "https://registry.example.com/v2/": dial tcp: lookup registry.example.com: no such host`
+
+				err := client.Pull("registry.example.com/my-image", false)
+				Expect(err).To(Equal(piper.PullError{
+					Image:   "registry.example.com/my-image",
+					Kind:    piper.PullNetworkError,
+					Message: engine.PullFailure,
+				}))
+			})
+		})
+	})
+
+	Describe("Run", func() {
+		It("creates, starts and waits for the container, streaming its logs", func() {
+			engine.Logs = []byte("some-output\n")
+
+			err := client.Run([]string{"my-task.sh", "-my-arg"}, "my-image:1.0",
+				[]piper.DockerEnv{{Key: "VAR1", Value: "var-1"}},
+				[]piper.DockerVolumeMount{{LocalPath: "/local/input-1", RemotePath: "/tmp/build/input-1"}},
+				piper.DockerRunOptions{
+					Workdir:    "/tmp/build/input-1",
+					User:       "some-user",
+					Limits:     piper.ContainerLimits{CPU: 512, Memory: 1024},
+					Privileged: true,
+					Rm:         true,
+					Tty:        true,
+				}, false)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(engine.Requests).To(Equal([]string{
+				"POST /v1.41/containers/create",
+				"POST /v1.41/containers/some-container-id/start",
+				"GET /v1.41/containers/some-container-id/logs?follow=1&stderr=1&stdout=1",
+				"POST /v1.41/containers/some-container-id/wait",
+				"DELETE /v1.41/containers/some-container-id?force=1&v=1",
+			}))
+
+			config, err := json.Marshal(engine.Config)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(config).To(MatchJSON(`{
+				"Image": "my-image:1.0",
+				"Cmd": ["my-task.sh", "-my-arg"],
+				"Env": ["VAR1=var-1"],
+				"WorkingDir": "/tmp/build/input-1",
+				"User": "some-user",
+				"Tty": true,
+				"HostConfig": {
+					"Binds": ["/local/input-1:/tmp/build/input-1"],
+					"Privileged": true,
+					"CpuShares": 512,
+					"Memory": 1024
+				}
+			}`))
+
+			Expect(stdout.String()).To(Equal("some-output\n"))
+			Expect(stderr.String()).To(Equal("warning: some-warning\n"))
+		})
+
+		It("prints the create request with secrets redacted in dry-run", func() {
+			err := client.Run([]string{"my-task.sh"}, "my-image",
+				[]piper.DockerEnv{
+					{Key: "VAR1", Value: "var-1"},
+					{Key: "TOKEN", Value: "some-token", Secret: true},
+				},
+				[]piper.DockerVolumeMount{}, piper.DockerRunOptions{}, true)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(engine.Requests).To(BeEmpty())
+			Expect(stdout.String()).To(HavePrefix("POST /v1.41/containers/create "))
+			Expect(strings.TrimPrefix(stdout.String(), "POST /v1.41/containers/create ")).To(MatchJSON(`{
+				"Image": "my-image",
+				"Cmd": ["my-task.sh"],
+				"Env": ["VAR1=var-1", "TOKEN=[redacted]"],
+				"WorkingDir": "/tmp/build",
+				"Tty": false,
+				"HostConfig": {"Binds": [], "Privileged": false}
+			}`))
+		})
+
+		It("attaches stdin to an interactive container", func() {
+			client.Stdin = strings.NewReader("some-input\n")
+
+			err := client.Run([]string{"my-task.sh"}, "my-image", nil, nil, piper.DockerRunOptions{Interactive: true}, false)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(engine.requests()[:3]).To(Equal([]string{
+				"POST /v1.41/containers/create",
+				"POST /v1.41/containers/some-container-id/attach?stdin=1&stream=1",
+				"POST /v1.41/containers/some-container-id/start",
+			}))
+			Expect(engine.Config).To(HaveKeyWithValue("OpenStdin", true))
+			Expect(engine.Config).To(HaveKeyWithValue("StdinOnce", true))
+			Expect(engine.stdin()).To(Equal("some-input\n"))
+		})
+
+		It("names the container", func() {
+			err := client.Run([]string{"my-task.sh"}, "my-image", nil, nil, piper.DockerRunOptions{Name: "piper-some-name"}, false)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(engine.Requests[0]).To(Equal("POST /v1.41/containers/create?name=piper-some-name"))
+		})
+
+		It("stops and removes the container when the context is cancelled", func() {
+			engine.BlockLogs = true
+
+			ctx, cancel := context.WithCancel(context.Background())
+			go func() {
+				defer GinkgoRecover()
+
+				Eventually(engine.requests).Should(ContainElement(HavePrefix("GET /v1.41/containers/some-container-id/logs")))
+				cancel()
+			}()
+
+			err := client.RunContext(ctx, []string{"my-task.sh"}, "my-image", nil, nil, piper.DockerRunOptions{
+				Rm:              true,
+				StopGracePeriod: 5 * time.Second,
+			}, false)
+			Expect(err).To(Equal(context.Canceled))
+
+			Expect(engine.requests()).To(Equal([]string{
+				"POST /v1.41/containers/create",
+				"POST /v1.41/containers/some-container-id/start",
+				"GET /v1.41/containers/some-container-id/logs?follow=1&stderr=1&stdout=1",
+				"POST /v1.41/containers/some-container-id/stop?t=5",
+				"DELETE /v1.41/containers/some-container-id?force=1&v=1",
+			}))
+		})
+
+		Context("failure cases", func() {
+			It("returns the exit status of the container", func() {
+				engine.StatusCode = 3
+
+				err := client.Run([]string{"my-task.sh"}, "my-image", nil, nil, piper.DockerRunOptions{}, false)
+				Expect(err).To(Equal(piper.ContainerExitError{Status: 3, ContainerID: "some-container-id"}))
+				Expect(err).To(MatchError("container some-container-id exited with status 3"))
+			})
+
+			It("keeps the container when the task fails with KeepOnFailure", func() {
+				engine.StatusCode = 3
+
+				err := client.Run([]string{"my-task.sh"}, "my-image", nil, nil, piper.DockerRunOptions{
+					Rm:            true,
+					KeepOnFailure: true,
+				}, false)
+				Expect(err).To(Equal(piper.ContainerExitError{Status: 3, ContainerID: "some-container-id"}))
+
+				Expect(engine.requests()).NotTo(ContainElement(HavePrefix("DELETE ")))
+			})
+
+			It("returns the error message of the daemon", func() {
+				engine.CreateFailure = "No such image: my-image:latest"
+
+				err := client.Run([]string{"my-task.sh"}, "my-image", nil, nil, piper.DockerRunOptions{}, false)
+				Expect(err).To(MatchError("docker engine responded with status 404: No such image: my-image:latest"))
+				Expect(err).To(BeAssignableToTypeOf(piper.DockerEngineError{}))
+			})
+		})
+	})
+
+	Describe("StreamLogs", func() {
+		It("splits multiplexed stdout and stderr when there is no tty", func() {
+			var logs bytes.Buffer
+			for _, frame := range []struct {
+				stream byte
+				output string
+			}{
+				{1, "some-stdout\n"},
+				{2, "some-stderr\n"},
+				{1, "more-stdout\n"},
+			} {
+				header := make([]byte, 8)
+				header[0] = frame.stream
+				binary.BigEndian.PutUint32(header[4:], uint32(len(frame.output)))
+				logs.Write(header)
+				logs.WriteString(frame.output)
+			}
+			engine.Logs = logs.Bytes()
+
+			Expect(client.StreamLogs("some-container-id", false)).To(Succeed())
+
+			Expect(stdout.String()).To(Equal("some-stdout\nmore-stdout\n"))
+			Expect(stderr.String()).To(Equal("some-stderr\n"))
+		})
+	})
+})
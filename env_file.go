@@ -0,0 +1,72 @@
+package piper
+
+import (
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strings"
+)
+
+var envFileKeyPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// ParseEnvFile reads a dotenv file of KEY=VALUE lines. Blank lines and lines
+// starting with # are skipped, a leading "export " is allowed, and values may
+// be wrapped in single quotes, taken literally, or double quotes, which
+// understand \n, \t, \" and \\ escapes.
+func ParseEnvFile(path string) ([]DockerEnv, error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var envVars []DockerEnv
+	for i, line := range strings.Split(string(contents), "\n") {
+		line = strings.TrimSpace(strings.TrimSuffix(line, "\r"))
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		key, value, err := parseEnvFileLine(line)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse env file %s: line %d: %s", path, i+1, err)
+		}
+
+		envVars = append(envVars, DockerEnv{
+			Key:    key,
+			Value:  value,
+			Source: EnvSourceEnvFile,
+		})
+	}
+
+	return envVars, nil
+}
+
+func parseEnvFileLine(line string) (string, string, error) {
+	line = strings.TrimPrefix(line, "export ")
+
+	parts := strings.SplitN(line, "=", 2)
+	if len(parts) != 2 {
+		return "", "", fmt.Errorf("%q must be of form <name>=<value>", line)
+	}
+
+	key, value := strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+	if !envFileKeyPattern.MatchString(key) {
+		return "", "", fmt.Errorf("invalid name %q", key)
+	}
+
+	if len(value) == 0 || (value[0] != '"' && value[0] != '\'') {
+		return key, value, nil
+	}
+
+	quote := value[0]
+	if len(value) < 2 || value[len(value)-1] != quote {
+		return "", "", fmt.Errorf("unterminated quoted value for %q", key)
+	}
+	value = value[1 : len(value)-1]
+
+	if quote == '\'' {
+		return key, value, nil
+	}
+
+	return key, strings.NewReplacer(`\n`, "\n", `\t`, "\t", `\"`, `"`, `\\`, `\`).Replace(value), nil
+}
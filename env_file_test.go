@@ -0,0 +1,73 @@
+package piper_test
+
+import (
+	"io/ioutil"
+	"os"
+
+	"github.com/ryanmoran/piper"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ParseEnvFile", func() {
+	var envFilePath string
+
+	BeforeEach(func() {
+		tempFile, err := ioutil.TempFile("", "")
+		Expect(err).NotTo(HaveOccurred())
+
+		envFilePath = tempFile.Name()
+		Expect(tempFile.Close()).To(Succeed())
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(envFilePath)).To(Succeed())
+	})
+
+	It("parses a dotenv file", func() {
+		Expect(ioutil.WriteFile(envFilePath, []byte(`# a comment
+VAR1=var-1
+
+export VAR2 = var-2
+VAR3="line-1\nline-2 \"quoted\""
+VAR4='literal \n $HOME'
+VAR5=
+VAR6=a=b
+`), 0644)).To(Succeed())
+
+		envVars, err := piper.ParseEnvFile(envFilePath)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(envVars).To(Equal([]piper.DockerEnv{
+			{Key: "VAR1", Value: "var-1", Source: piper.EnvSourceEnvFile},
+			{Key: "VAR2", Value: "var-2", Source: piper.EnvSourceEnvFile},
+			{Key: "VAR3", Value: "line-1\nline-2 \"quoted\"", Source: piper.EnvSourceEnvFile},
+			{Key: "VAR4", Value: `literal \n $HOME`, Source: piper.EnvSourceEnvFile},
+			{Key: "VAR5", Value: "", Source: piper.EnvSourceEnvFile},
+			{Key: "VAR6", Value: "a=b", Source: piper.EnvSourceEnvFile},
+		}))
+	})
+
+	Context("failure cases", func() {
+		It("returns an error for a line without an equals sign", func() {
+			Expect(ioutil.WriteFile(envFilePath, []byte("VAR1=var-1\nVAR2\n"), 0644)).To(Succeed())
+
+			_, err := piper.ParseEnvFile(envFilePath)
+			Expect(err).To(MatchError(ContainSubstring(`line 2: "VAR2" must be of form <name>=<value>`)))
+		})
+
+		It("returns an error for an invalid name", func() {
+			Expect(ioutil.WriteFile(envFilePath, []byte("MY-VAR=value\n"), 0644)).To(Succeed())
+
+			_, err := piper.ParseEnvFile(envFilePath)
+			Expect(err).To(MatchError(ContainSubstring(`line 1: invalid name "MY-VAR"`)))
+		})
+
+		It("returns an error for an unterminated quote", func() {
+			Expect(ioutil.WriteFile(envFilePath, []byte(`VAR1="value`), 0644)).To(Succeed())
+
+			_, err := piper.ParseEnvFile(envFilePath)
+			Expect(err).To(MatchError(ContainSubstring(`line 1: unterminated quoted value for "VAR1"`)))
+		})
+	})
+})
@@ -1,26 +1,125 @@
 package piper
 
-import "strings"
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+)
 
-type EnvVarBuilder struct{}
+// EnvSource records where the value of an env var came from.
+type EnvSource string
+
+const (
+	EnvSourceTask    EnvSource = "task"
+	EnvSourceHost    EnvSource = "host"
+	EnvSourceEnvFile EnvSource = "env-file"
+	EnvSourceFlag    EnvSource = "flag"
+)
+
+// EnvVarBuilder builds the env of the task container. From lowest to highest
+// precedence, values come from the task params, the host environment
+// (for declared params, and for undeclared vars matching a PassEnv glob
+// pattern), each of the dotenv EnvFiles in order, and KEY=VALUE Overrides.
+// A host env var that is set overrides a param even when it is empty, and
+// the keys listed in Unset are left out of the env altogether.
+type EnvVarBuilder struct {
+	PassEnv   []string
+	EnvFiles  []string
+	Overrides []string
+	Unset     []string
+}
+
+// Build returns the env vars sorted by key.
+func (b EnvVarBuilder) Build(environment []string, params map[string]string) ([]DockerEnv, error) {
+	for _, pattern := range b.PassEnv {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("could not parse pass-env pattern %q: %s", pattern, err)
+		}
+	}
 
-func (b EnvVarBuilder) Build(environment []string, params map[string]string) []DockerEnv {
 	env := make(map[string]string)
 	for _, variable := range environment {
 		parts := strings.SplitN(variable, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			continue
+		}
 		env[parts[0]] = parts[1]
 	}
 
-	var envVars []DockerEnv
+	envVars := make(map[string]DockerEnv)
 	for key, value := range params {
-		if env[key] != "" {
-			value = env[key]
+		source := EnvSourceTask
+		if hostValue, ok := env[key]; ok {
+			value = hostValue
+			source = EnvSourceHost
+		}
+		envVars[key] = DockerEnv{Key: key, Value: value, Source: source}
+	}
+
+	for key, value := range env {
+		if _, ok := envVars[key]; !ok && b.passes(key) {
+			envVars[key] = DockerEnv{Key: key, Value: value, Source: EnvSourceHost}
+		}
+	}
+
+	for _, file := range b.EnvFiles {
+		fileVars, err := ParseEnvFile(file)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, envVar := range fileVars {
+			envVars[envVar.Key] = envVar
+		}
+	}
+
+	for _, pair := range b.Overrides {
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("could not parse env var %q. must be of form <name>=<value>", pair)
+		}
+
+		envVars[parts[0]] = DockerEnv{Key: parts[0], Value: parts[1], Source: EnvSourceFlag}
+	}
+
+	for _, key := range b.Unset {
+		delete(envVars, key)
+	}
+
+	var sorted []DockerEnv
+	for _, envVar := range envVars {
+		sorted = append(sorted, envVar)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Key < sorted[j].Key
+	})
+
+	return sorted, nil
+}
+
+// EmptyParams lists, in order, the params that are still empty in the built
+// env. Concourse tasks mark required params by leaving them empty, so these
+// usually need to be given on the host or with -e. Params that were
+// explicitly unset are not listed.
+func EmptyParams(envVars []DockerEnv, params map[string]string) []string {
+	var empty []string
+	for _, envVar := range envVars {
+		if _, ok := params[envVar.Key]; ok && envVar.Value == "" {
+			empty = append(empty, envVar.Key)
+		}
+	}
+	sort.Strings(empty)
+
+	return empty
+}
+
+func (b EnvVarBuilder) passes(key string) bool {
+	for _, pattern := range b.PassEnv {
+		if matched, _ := path.Match(pattern, key); matched {
+			return true
 		}
-		envVars = append(envVars, DockerEnv{
-			Key:   key,
-			Value: value,
-		})
 	}
 
-	return envVars
+	return false
 }
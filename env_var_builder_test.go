@@ -1,6 +1,10 @@
 package piper_test
 
 import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
 	"github.com/ryanmoran/piper"
 
 	. "github.com/onsi/ginkgo"
@@ -8,22 +12,31 @@ import (
 )
 
 var _ = Describe("EnvVarBuilder", func() {
-	It("returns a list of environment variables", func() {
-		vars := piper.EnvVarBuilder{}.Build([]string{
+	It("returns a list of environment variables sorted by key", func() {
+		vars, err := piper.EnvVarBuilder{}.Build([]string{
 			"VAR1=var-1",
 			"VAR3=var-3",
 		}, map[string]string{
-			"VAR1": "default-var-1",
 			"VAR2": "default-var-2",
+			"VAR1": "default-var-1",
+			"VAR0": "default-var-0",
 		})
-		Expect(vars).To(ConsistOf([]piper.DockerEnv{
+		Expect(err).NotTo(HaveOccurred())
+		Expect(vars).To(Equal([]piper.DockerEnv{
+			{
+				Key:    "VAR0",
+				Value:  "default-var-0",
+				Source: piper.EnvSourceTask,
+			},
 			{
-				Key:   "VAR1",
-				Value: "var-1",
+				Key:    "VAR1",
+				Value:  "var-1",
+				Source: piper.EnvSourceHost,
 			},
 			{
-				Key:   "VAR2",
-				Value: "default-var-2",
+				Key:    "VAR2",
+				Value:  "default-var-2",
+				Source: piper.EnvSourceTask,
 			},
 		}))
 
@@ -31,17 +44,198 @@ var _ = Describe("EnvVarBuilder", func() {
 
 	Context("when env vars have '=' signs in the value", func() {
 		It("returns a list of environment variables with '=' signs still in their place", func() {
-			vars := piper.EnvVarBuilder{}.Build([]string{
+			vars, err := piper.EnvVarBuilder{}.Build([]string{
 				"VAR1=var-1==42=",
 			}, map[string]string{
 				"VAR1": "meow",
 			})
+			Expect(err).NotTo(HaveOccurred())
 			Expect(vars).To(ConsistOf([]piper.DockerEnv{
 				{
-					Key:   "VAR1",
-					Value: "var-1==42=",
+					Key:    "VAR1",
+					Value:  "var-1==42=",
+					Source: piper.EnvSourceHost,
+				},
+			}))
+		})
+	})
+
+	Context("when a host env var is set to an empty string", func() {
+		It("overrides the param with the empty string", func() {
+			vars, err := piper.EnvVarBuilder{}.Build([]string{
+				"VAR1=",
+			}, map[string]string{
+				"VAR1": "default-var-1",
+				"VAR2": "default-var-2",
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(vars).To(Equal([]piper.DockerEnv{
+				{Key: "VAR1", Value: "", Source: piper.EnvSourceHost},
+				{Key: "VAR2", Value: "default-var-2", Source: piper.EnvSourceTask},
+			}))
+		})
+	})
+
+	Context("when a host env var is not set", func() {
+		It("keeps the param, even when it is empty", func() {
+			vars, err := piper.EnvVarBuilder{}.Build([]string{
+				"OTHER=other",
+			}, map[string]string{
+				"VAR1": "",
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(vars).To(Equal([]piper.DockerEnv{
+				{Key: "VAR1", Value: "", Source: piper.EnvSourceTask},
+			}))
+		})
+	})
+
+	Context("when the host environment has malformed entries", func() {
+		It("skips them", func() {
+			vars, err := piper.EnvVarBuilder{
+				PassEnv: []string{"*"},
+			}.Build([]string{
+				"VAR1",
+				"=C:=C:\\",
+				"",
+				"VAR2=var-2",
+			}, map[string]string{
+				"VAR1": "default-var-1",
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(vars).To(Equal([]piper.DockerEnv{
+				{Key: "VAR1", Value: "default-var-1", Source: piper.EnvSourceTask},
+				{Key: "VAR2", Value: "var-2", Source: piper.EnvSourceHost},
+			}))
+		})
+	})
+
+	Context("when env vars are explicitly unset", func() {
+		It("leaves them out, whatever their source", func() {
+			vars, err := piper.EnvVarBuilder{
+				PassEnv:   []string{"HOST_*"},
+				Overrides: []string{"FLAG_VAR=flag"},
+				Unset:     []string{"VAR1", "HOST_VAR", "FLAG_VAR", "NOT_SET"},
+			}.Build([]string{
+				"HOST_VAR=host",
+				"VAR1=host",
+			}, map[string]string{
+				"VAR1": "default-var-1",
+				"VAR2": "default-var-2",
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(vars).To(Equal([]piper.DockerEnv{
+				{Key: "VAR2", Value: "default-var-2", Source: piper.EnvSourceTask},
+			}))
+		})
+	})
+
+	Context("when host env vars match a pass-env pattern", func() {
+		It("forwards them even though they are not params", func() {
+			vars, err := piper.EnvVarBuilder{
+				PassEnv: []string{"AWS_*"},
+			}.Build([]string{
+				"AWS_REGION=us-east-1",
+				"AWS_PROFILE=dev",
+				"HOME=/home/me",
+			}, map[string]string{
+				"VAR1": "var-1",
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(vars).To(Equal([]piper.DockerEnv{
+				{Key: "AWS_PROFILE", Value: "dev", Source: piper.EnvSourceHost},
+				{Key: "AWS_REGION", Value: "us-east-1", Source: piper.EnvSourceHost},
+				{Key: "VAR1", Value: "var-1", Source: piper.EnvSourceTask},
+			}))
+		})
+	})
+
+	Context("when env files and overrides are given", func() {
+		var tempDir string
+
+		BeforeEach(func() {
+			var err error
+			tempDir, err = ioutil.TempDir("", "")
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(ioutil.WriteFile(filepath.Join(tempDir, "first.env"), []byte("VAR1=first\nVAR2=first\nVAR3=first\n"), 0644)).To(Succeed())
+			Expect(ioutil.WriteFile(filepath.Join(tempDir, "second.env"), []byte("VAR2=second\n"), 0644)).To(Succeed())
+		})
+
+		AfterEach(func() {
+			Expect(os.RemoveAll(tempDir)).To(Succeed())
+		})
+
+		It("applies params, the host env, env files in order and then overrides", func() {
+			vars, err := piper.EnvVarBuilder{
+				EnvFiles: []string{
+					filepath.Join(tempDir, "first.env"),
+					filepath.Join(tempDir, "second.env"),
 				},
+				Overrides: []string{"VAR3=flag", "VAR5=flag=with=equals"},
+			}.Build([]string{
+				"VAR1=host",
+				"VAR4=host",
+			}, map[string]string{
+				"VAR1": "task",
+				"VAR4": "task",
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(vars).To(Equal([]piper.DockerEnv{
+				{Key: "VAR1", Value: "first", Source: piper.EnvSourceEnvFile},
+				{Key: "VAR2", Value: "second", Source: piper.EnvSourceEnvFile},
+				{Key: "VAR3", Value: "flag", Source: piper.EnvSourceFlag},
+				{Key: "VAR4", Value: "host", Source: piper.EnvSourceHost},
+				{Key: "VAR5", Value: "flag=with=equals", Source: piper.EnvSourceFlag},
 			}))
 		})
 	})
+
+	Describe("EmptyParams", func() {
+		It("lists the params that are empty after overrides", func() {
+			params := map[string]string{
+				"VAR1": "",
+				"VAR2": "",
+				"VAR3": "",
+				"VAR4": "default-var-4",
+				"VAR5": "",
+			}
+			builder := piper.EnvVarBuilder{
+				PassEnv: []string{"OTHER"},
+				Unset:   []string{"VAR5"},
+			}
+
+			vars, err := builder.Build([]string{
+				"VAR1=var-1",
+				"VAR4=",
+				"OTHER=",
+			}, params)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(piper.EmptyParams(vars, params)).To(Equal([]string{"VAR2", "VAR3", "VAR4"}))
+		})
+	})
+
+	Context("failure cases", func() {
+		It("returns an error when an override is malformed", func() {
+			_, err := piper.EnvVarBuilder{
+				Overrides: []string{"VAR1"},
+			}.Build(nil, nil)
+			Expect(err).To(MatchError(`could not parse env var "VAR1". must be of form <name>=<value>`))
+		})
+
+		It("returns an error when a pass-env pattern is malformed", func() {
+			_, err := piper.EnvVarBuilder{
+				PassEnv: []string{"AWS_["},
+			}.Build(nil, nil)
+			Expect(err).To(MatchError(ContainSubstring(`could not parse pass-env pattern "AWS_["`)))
+		})
+
+		It("returns an error when an env file does not exist", func() {
+			_, err := piper.EnvVarBuilder{
+				EnvFiles: []string{"no-such-file"},
+			}.Build(nil, nil)
+			Expect(err).To(MatchError(ContainSubstring("no such file or directory")))
+		})
+	})
 })
@@ -1,3 +1,30 @@
 package dockerconfig
 
 const InvocationsPath = "/tmp/piper/docker-invocations"
+
+// RegistryConfigsPath is where the fake docker login and pull record the
+// DOCKER_CONFIG they were run with.
+const RegistryConfigsPath = "/tmp/piper/docker-registry-configs"
+
+// RunExitStatusEnv names an environment variable holding the exit status of
+// the fake docker run.
+const RunExitStatusEnv = "FAKE_DOCKER_RUN_EXIT_STATUS"
+
+// RunDurationEnv names an environment variable holding how long the fake
+// docker run keeps running, as a duration like 1m.
+const RunDurationEnv = "FAKE_DOCKER_RUN_DURATION"
+
+// ContainerRunningEnv names an environment variable that makes the fake
+// docker inspect report the container as running when it is true.
+const ContainerRunningEnv = "FAKE_DOCKER_CONTAINER_RUNNING"
+
+// MissingImagesEnv names an environment variable holding a comma separated
+// list of images that the fake docker image inspect does not find.
+const MissingImagesEnv = "FAKE_DOCKER_MISSING_IMAGES"
+
+// PullFailuresEnv names an environment variable holding how many times the
+// fake docker pull fails, and PullErrorEnv the error it prints when it does.
+const (
+	PullFailuresEnv = "FAKE_DOCKER_PULL_FAILURES"
+	PullErrorEnv    = "FAKE_DOCKER_PULL_ERROR"
+)
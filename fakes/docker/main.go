@@ -1,25 +1,36 @@
 package main
 
 import (
+	"fmt"
 	"log"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/ryanmoran/piper/fakes/docker/dockerconfig"
 )
 
 var failPull, failRun bool
 
+// main stands in for the docker CLI and the CLIs that share its commands,
+// like podman and nerdctl, when it is linked under their names.
 func main() {
 	command := strings.Join(os.Args, " ")
 
-	if failPull && strings.Contains(command, "docker pull") {
+	var subcommand string
+	if len(os.Args) > 1 {
+		subcommand = os.Args[1]
+	}
+
+	if failPull && subcommand == "pull" {
 		log.Fatalln("failed to pull")
 	}
 
-	if failRun && strings.Contains(command, "docker run") {
-		log.Fatalln("failed to run")
+	if failRun && subcommand == "run" {
+		log.Println("failed to run")
+		os.Exit(125)
 	}
 
 	err := os.MkdirAll(filepath.Dir(dockerconfig.InvocationsPath), 0755)
@@ -41,4 +52,67 @@ func main() {
 	if err != nil {
 		log.Fatalln(err)
 	}
+
+	if subcommand == "login" || subcommand == "pull" {
+		configs, err := os.OpenFile(dockerconfig.RegistryConfigsPath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+		if err != nil {
+			log.Fatalln(err)
+		}
+
+		_, err = configs.WriteString(os.Getenv("DOCKER_CONFIG") + "\n")
+		if err != nil {
+			log.Fatalln(err)
+		}
+
+		err = configs.Close()
+		if err != nil {
+			log.Fatalln(err)
+		}
+	}
+
+	if failures := os.Getenv(dockerconfig.PullFailuresEnv); failures != "" && subcommand == "pull" {
+		pullFailures, err := strconv.Atoi(failures)
+		if err != nil {
+			log.Fatalln(err)
+		}
+
+		contents, err := os.ReadFile(dockerconfig.InvocationsPath)
+		if err != nil {
+			log.Fatalln(err)
+		}
+
+		if pulls := strings.Count(string(contents), " pull "); pulls <= pullFailures {
+			fmt.Fprintln(os.Stderr, os.Getenv(dockerconfig.PullErrorEnv))
+			os.Exit(1)
+		}
+	}
+
+	if subcommand == "image" && len(os.Args) > 3 && os.Args[2] == "inspect" {
+		image := os.Args[len(os.Args)-1]
+		for _, missing := range strings.Split(os.Getenv(dockerconfig.MissingImagesEnv), ",") {
+			if image == missing {
+				log.Fatalf("Error: No such image: %s", image)
+			}
+		}
+	}
+
+	if subcommand == "inspect" {
+		fmt.Println(os.Getenv(dockerconfig.ContainerRunningEnv) == "true")
+	}
+
+	if duration := os.Getenv(dockerconfig.RunDurationEnv); duration != "" && subcommand == "run" {
+		runDuration, err := time.ParseDuration(duration)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		time.Sleep(runDuration)
+	}
+
+	if status := os.Getenv(dockerconfig.RunExitStatusEnv); status != "" && subcommand == "run" {
+		exitStatus, err := strconv.Atoi(status)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		os.Exit(exitStatus)
+	}
 }
@@ -0,0 +1,148 @@
+package piper
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// PullErrorKind classifies why an image could not be pulled.
+type PullErrorKind string
+
+const (
+	PullAuthDenied       PullErrorKind = "auth denied"
+	PullNotFound         PullErrorKind = "manifest not found"
+	PullNotFoundOrDenied PullErrorKind = "repository not found or access denied"
+	PullRateLimited      PullErrorKind = "rate limited"
+	PullNetworkError     PullErrorKind = "network error"
+	PullRegistryError    PullErrorKind = "registry error"
+	PullUnknownError     PullErrorKind = "unknown error"
+)
+
+// pullErrorPatterns match the output of the runtimes and the registry to the
+// kind of failure, in the order they are checked. Docker Hub reports both
+// missing and private repositories as "pull access denied ... repository does
+// not exist or may require 'docker login'", which is checked for first, as it
+// also matches the patterns of missing images and denied access.
+var pullErrorPatterns = []struct {
+	kind     PullErrorKind
+	patterns []string
+}{
+	{PullRateLimited, []string{"toomanyrequests", "too many requests", "rate limit"}},
+	{PullNotFoundOrDenied, []string{"pull access denied", "may require 'docker login'"}},
+	{PullNotFound, []string{"manifest unknown", "not found", "does not exist", "name unknown", "no such image"}},
+	{PullAuthDenied, []string{"unauthorized", "authentication required", "denied", "forbidden", "incorrect username or password"}},
+	{PullNetworkError, []string{"dial tcp", "no such host", "connection refused", "connection reset", "i/o timeout", "tls handshake timeout", "network is unreachable", "temporary failure in name resolution", "timeout exceeded", "unexpected eof"}},
+	{PullRegistryError, []string{"internal server error", "bad gateway", "service unavailable", "gateway timeout"}},
+}
+
+var pullErrorHints = map[PullErrorKind]string{
+	PullAuthDenied:       "check the registry credentials",
+	PullNotFound:         "check the image repository and tag",
+	PullNotFoundOrDenied: "check the image repository, and the registry credentials if it is private",
+	PullRateLimited:      "log in to the registry or wait for the limit to reset",
+	PullNetworkError:     "check the network and the registry address",
+	PullRegistryError:    "the registry failed to serve the image",
+}
+
+// PullError is returned when an image could not be pulled. Message is the
+// error reported by the runtime.
+type PullError struct {
+	Image    string
+	Kind     PullErrorKind
+	Message  string
+	Attempts int
+}
+
+// classifyPullError classifies the output of a failed pull, keeping its last
+// line as the message, which is where the runtimes report the error.
+func classifyPullError(image, output string) PullError {
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	pullError := PullError{
+		Image:   image,
+		Kind:    PullUnknownError,
+		Message: strings.TrimSpace(lines[len(lines)-1]),
+	}
+
+	lowered := strings.ToLower(output)
+	for _, kind := range pullErrorPatterns {
+		for _, pattern := range kind.patterns {
+			if strings.Contains(lowered, pattern) {
+				pullError.Kind = kind.kind
+				return pullError
+			}
+		}
+	}
+
+	return pullError
+}
+
+func (e PullError) Error() string {
+	reason := string(e.Kind)
+	if hint, ok := pullErrorHints[e.Kind]; ok {
+		reason = fmt.Sprintf("%s (%s)", reason, hint)
+	}
+
+	if e.Attempts > 1 {
+		return fmt.Sprintf("could not pull %s after %d attempts: %s: %s", e.Image, e.Attempts, reason, e.Message)
+	}
+
+	return fmt.Sprintf("could not pull %s: %s: %s", e.Image, reason, e.Message)
+}
+
+// Temporary reports whether pulling the image again may succeed.
+func (e PullError) Temporary() bool {
+	switch e.Kind {
+	case PullRateLimited, PullNetworkError, PullRegistryError:
+		return true
+	}
+
+	return false
+}
+
+// ImagePuller pulls images with the runtime, retrying temporary failures up to
+// Retries times. The wait before each retry starts at Backoff and doubles up
+// to MaxBackoff.
+type ImagePuller struct {
+	Runtime    Runtime
+	Retries    int
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	Stderr     io.Writer
+
+	// Sleep waits between retries, time.Sleep by default.
+	Sleep func(time.Duration)
+}
+
+func (p ImagePuller) Pull(image string, dryRun bool) error {
+	sleep := p.Sleep
+	if sleep == nil {
+		sleep = time.Sleep
+	}
+
+	backoff := p.Backoff
+	for attempt := 1; ; attempt++ {
+		err := p.Runtime.Pull(image, dryRun)
+
+		pullError, ok := err.(PullError)
+		if !ok {
+			return err
+		}
+		pullError.Attempts = attempt
+
+		if !pullError.Temporary() || attempt > p.Retries {
+			return pullError
+		}
+
+		if p.Stderr != nil {
+			fmt.Fprintf(p.Stderr, "could not pull %s: %s, retrying in %s (%d of %d retries)\n", image, pullError.Kind, backoff, attempt, p.Retries)
+		}
+		sleep(backoff)
+
+		backoff *= 2
+		if p.MaxBackoff > 0 && backoff > p.MaxBackoff {
+			backoff = p.MaxBackoff
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package piper_test
+
+import (
+	"bytes"
+	"errors"
+	"time"
+
+	"github.com/ryanmoran/piper"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type fakePullRuntime struct {
+	piper.DockerClient
+
+	Errors []error
+	Pulls  int
+}
+
+func (r *fakePullRuntime) Pull(image string, dryRun bool) error {
+	r.Pulls++
+	if len(r.Errors) == 0 {
+		return nil
+	}
+
+	err := r.Errors[0]
+	r.Errors = r.Errors[1:]
+	return err
+}
+
+var _ = Describe("ImagePuller", func() {
+	var (
+		runtime *fakePullRuntime
+		stderr  *bytes.Buffer
+		sleeps  []time.Duration
+		puller  piper.ImagePuller
+
+		networkError piper.PullError
+	)
+
+	BeforeEach(func() {
+		runtime = &fakePullRuntime{}
+		stderr = bytes.NewBuffer([]byte{})
+		sleeps = nil
+
+		puller = piper.ImagePuller{
+			Runtime:    runtime,
+			Retries:    3,
+			Backoff:    time.Second,
+			MaxBackoff: 3 * time.Second,
+			Stderr:     stderr,
+			Sleep: func(duration time.Duration) {
+				sleeps = append(sleeps, duration)
+			},
+		}
+
+		networkError = piper.PullError{Image: "my-image", Kind: piper.PullNetworkError, Message: "dial tcp: i/o timeout"}
+	})
+
+	It("pulls the image", func() {
+		Expect(puller.Pull("my-image", false)).To(Succeed())
+
+		Expect(runtime.Pulls).To(Equal(1))
+		Expect(sleeps).To(BeEmpty())
+	})
+
+	It("retries temporary failures with exponential backoff", func() {
+		runtime.Errors = []error{networkError, networkError, networkError}
+
+		Expect(puller.Pull("my-image", false)).To(Succeed())
+
+		Expect(runtime.Pulls).To(Equal(4))
+		Expect(sleeps).To(Equal([]time.Duration{time.Second, 2 * time.Second, 3 * time.Second}))
+		Expect(stderr.String()).To(Equal(`could not pull my-image: network error, retrying in 1s (1 of 3 retries)
+could not pull my-image: network error, retrying in 2s (2 of 3 retries)
+could not pull my-image: network error, retrying in 3s (3 of 3 retries)
+`))
+	})
+
+	Context("failure cases", func() {
+		It("returns the last error once the retries run out", func() {
+			runtime.Errors = []error{networkError, networkError, networkError, networkError}
+
+			err := puller.Pull("my-image", false)
+			Expect(err).To(MatchError("could not pull my-image after 4 attempts: network error (check the network and the registry address): dial tcp: i/o timeout"))
+			Expect(runtime.Pulls).To(Equal(4))
+		})
+
+		It("does not retry failures that will not go away", func() {
+			runtime.Errors = []error{piper.PullError{Image: "my-image", Kind: piper.PullAuthDenied, Message: "unauthorized: authentication required"}}
+
+			err := puller.Pull("my-image", false)
+			Expect(err).To(MatchError("could not pull my-image: auth denied (check the registry credentials): unauthorized: authentication required"))
+			Expect(runtime.Pulls).To(Equal(1))
+			Expect(sleeps).To(BeEmpty())
+		})
+
+		It("does not retry errors that are not pull errors", func() {
+			runtime.Errors = []error{errors.New("executable file not found in $PATH")}
+
+			err := puller.Pull("my-image", false)
+			Expect(err).To(MatchError("executable file not found in $PATH"))
+			Expect(runtime.Pulls).To(Equal(1))
+		})
+	})
+})
@@ -0,0 +1,148 @@
+package piper
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+const (
+	DockerImageResourceType   = "docker-image"
+	RegistryImageResourceType = "registry-image"
+)
+
+type RegistryMirror struct {
+	Host     string `yaml:"host"`
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+}
+
+// UnmarshalYAML accepts both the docker-image form, a mirror URL string, and
+// the registry-image form, a mapping with host and credentials.
+func (m *RegistryMirror) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var mirrorURL string
+	err := unmarshal(&mirrorURL)
+	if err == nil {
+		m.Host = mirrorURL
+		if parsedURL, err := url.Parse(mirrorURL); err == nil && parsedURL.Host != "" {
+			m.Host = parsedURL.Host
+		}
+		return nil
+	}
+
+	type registryMirror RegistryMirror
+	var mirror registryMirror
+	err = unmarshal(&mirror)
+	if err != nil {
+		return err
+	}
+
+	*m = RegistryMirror(mirror)
+	return nil
+}
+
+type ImageResourceSource struct {
+	Repository string `yaml:"repository"`
+	Tag        string `yaml:"tag"`
+	Username   string `yaml:"username"`
+	Password   string `yaml:"password"`
+
+	// InsecureRegistries is modeled for completeness. Whether a registry may
+	// be reached insecurely is configured on the local docker daemon.
+	InsecureRegistries []string       `yaml:"insecure_registries"`
+	RegistryMirror     RegistryMirror `yaml:"registry_mirror"`
+}
+
+func (i ImageResourceSource) String() string {
+	if "" != i.Tag {
+		return fmt.Sprintf("%s:%s", i.Repository, i.Tag)
+	}
+	return i.Repository
+}
+
+type ImageResourceVersion struct {
+	Digest string `yaml:"digest"`
+}
+
+type ImageResource struct {
+	Type    string                 `yaml:"type"`
+	Source  ImageResourceSource    `yaml:"source"`
+	Params  map[string]interface{} `yaml:"params"`
+	Version ImageResourceVersion   `yaml:"version"`
+}
+
+// Image returns the docker reference to pull and run. A version digest pins
+// the image regardless of the tag, and a registry mirror is used in place of
+// Docker Hub.
+func (i ImageResource) Image() (string, error) {
+	switch i.Type {
+	case DockerImageResourceType, RegistryImageResourceType:
+	default:
+		return "", fmt.Errorf("unsupported image_resource type %q: must be one of %s, %s", i.Type, DockerImageResourceType, RegistryImageResourceType)
+	}
+
+	if i.Source.Repository == "" {
+		return "", fmt.Errorf("image_resource source.repository is required")
+	}
+
+	repository := i.Source.Repository
+	if i.Source.RegistryMirror.Host != "" && registryHost(repository) == "" {
+		if !strings.Contains(repository, "/") {
+			repository = "library/" + repository
+		}
+		repository = fmt.Sprintf("%s/%s", i.Source.RegistryMirror.Host, repository)
+	}
+
+	if i.Version.Digest != "" {
+		return fmt.Sprintf("%s@%s", repository, i.Version.Digest), nil
+	}
+
+	return ImageResourceSource{Repository: repository, Tag: i.Source.Tag}.String(), nil
+}
+
+// Auth returns the credentials needed to pull the image, if any were given.
+func (i ImageResource) Auth() (DockerRegistryAuth, bool) {
+	mirror := i.Source.RegistryMirror
+	if mirror.Host != "" && registryHost(i.Source.Repository) == "" {
+		if mirror.Username == "" {
+			return DockerRegistryAuth{}, false
+		}
+
+		return DockerRegistryAuth{
+			Registry: mirror.Host,
+			Username: mirror.Username,
+			Password: mirror.Password,
+		}, true
+	}
+
+	if i.Source.Username == "" {
+		return DockerRegistryAuth{}, false
+	}
+
+	return DockerRegistryAuth{
+		Registry: registryHost(i.Source.Repository),
+		Username: i.Source.Username,
+		Password: i.Source.Password,
+	}, true
+}
+
+// WithTag replaces the tag or digest of an image reference with the tag.
+func WithTag(image, tag string) string {
+	name, _ := splitImageReference(image)
+	return fmt.Sprintf("%s:%s", name, tag)
+}
+
+// registryHost returns the registry portion of a repository, or an empty
+// string for repositories on Docker Hub.
+func registryHost(repository string) string {
+	parts := strings.SplitN(repository, "/", 2)
+	if len(parts) == 1 {
+		return ""
+	}
+
+	if strings.ContainsAny(parts[0], ".:") || parts[0] == "localhost" {
+		return parts[0]
+	}
+
+	return ""
+}
@@ -0,0 +1,154 @@
+package piper_test
+
+import (
+	"github.com/ryanmoran/piper"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ImageResource", func() {
+	Describe("Image", func() {
+		It("returns the repository and tag", func() {
+			image, err := piper.ImageResource{
+				Type: "registry-image",
+				Source: piper.ImageResourceSource{
+					Repository: "some-repo/some-image",
+					Tag:        "some-tag",
+				},
+			}.Image()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(image).To(Equal("some-repo/some-image:some-tag"))
+		})
+
+		It("pins the image to the version digest", func() {
+			image, err := piper.ImageResource{
+				Type: "docker-image",
+				Source: piper.ImageResourceSource{
+					Repository: "some-repo/some-image",
+					Tag:        "some-tag",
+				},
+				Version: piper.ImageResourceVersion{Digest: "sha256:some-digest"},
+			}.Image()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(image).To(Equal("some-repo/some-image@sha256:some-digest"))
+		})
+
+		It("pulls Docker Hub images through the registry mirror", func() {
+			image, err := piper.ImageResource{
+				Type: "docker-image",
+				Source: piper.ImageResourceSource{
+					Repository:     "ubuntu",
+					RegistryMirror: piper.RegistryMirror{Host: "mirror.example.com"},
+				},
+			}.Image()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(image).To(Equal("mirror.example.com/library/ubuntu"))
+		})
+
+		It("does not use the registry mirror for other registries", func() {
+			image, err := piper.ImageResource{
+				Type: "docker-image",
+				Source: piper.ImageResourceSource{
+					Repository:     "registry.example.com/some-image",
+					RegistryMirror: piper.RegistryMirror{Host: "mirror.example.com"},
+				},
+			}.Image()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(image).To(Equal("registry.example.com/some-image"))
+		})
+
+		Context("failure cases", func() {
+			Context("when the type is not supported", func() {
+				It("returns an error", func() {
+					_, err := piper.ImageResource{
+						Type:   "s3",
+						Source: piper.ImageResourceSource{Repository: "some-image"},
+					}.Image()
+					Expect(err).To(MatchError(`unsupported image_resource type "s3": must be one of docker-image, registry-image`))
+				})
+			})
+
+			Context("when the repository is missing", func() {
+				It("returns an error", func() {
+					_, err := piper.ImageResource{Type: "registry-image"}.Image()
+					Expect(err).To(MatchError("image_resource source.repository is required"))
+				})
+			})
+		})
+	})
+
+	Describe("WithTag", func() {
+		It("tags the image", func() {
+			Expect(piper.WithTag("my-image", "1.0")).To(Equal("my-image:1.0"))
+			Expect(piper.WithTag("localhost:5000/my-image", "1.0")).To(Equal("localhost:5000/my-image:1.0"))
+		})
+
+		It("replaces the tag of the image", func() {
+			Expect(piper.WithTag("my-image:x.y", "1.0")).To(Equal("my-image:1.0"))
+			Expect(piper.WithTag("localhost:5000/my-image:x.y", "1.0")).To(Equal("localhost:5000/my-image:1.0"))
+		})
+
+		It("replaces the digest of the image", func() {
+			Expect(piper.WithTag("registry.example.com/my-image@sha256:my-digest", "1.0")).To(Equal("registry.example.com/my-image:1.0"))
+		})
+	})
+
+	Describe("Auth", func() {
+		It("returns the credentials for the registry of the repository", func() {
+			auth, ok := piper.ImageResource{
+				Source: piper.ImageResourceSource{
+					Repository: "registry.example.com:5000/some-image",
+					Username:   "some-user",
+					Password:   "some-password",
+				},
+			}.Auth()
+			Expect(ok).To(BeTrue())
+			Expect(auth).To(Equal(piper.DockerRegistryAuth{
+				Registry: "registry.example.com:5000",
+				Username: "some-user",
+				Password: "some-password",
+			}))
+		})
+
+		It("returns the credentials for Docker Hub without a registry", func() {
+			auth, ok := piper.ImageResource{
+				Source: piper.ImageResourceSource{
+					Repository: "some-repo/some-image",
+					Username:   "some-user",
+					Password:   "some-password",
+				},
+			}.Auth()
+			Expect(ok).To(BeTrue())
+			Expect(auth.Registry).To(BeEmpty())
+		})
+
+		It("returns the registry mirror credentials when pulling through the mirror", func() {
+			auth, ok := piper.ImageResource{
+				Source: piper.ImageResourceSource{
+					Repository: "some-repo/some-image",
+					Username:   "some-user",
+					Password:   "some-password",
+					RegistryMirror: piper.RegistryMirror{
+						Host:     "mirror.example.com",
+						Username: "mirror-user",
+						Password: "mirror-password",
+					},
+				},
+			}.Auth()
+			Expect(ok).To(BeTrue())
+			Expect(auth).To(Equal(piper.DockerRegistryAuth{
+				Registry: "mirror.example.com",
+				Username: "mirror-user",
+				Password: "mirror-password",
+			}))
+		})
+
+		It("returns false when there are no credentials", func() {
+			_, ok := piper.ImageResource{
+				Source: piper.ImageResourceSource{Repository: "some-image"},
+			}.Auth()
+			Expect(ok).To(BeFalse())
+		})
+	})
+})
@@ -0,0 +1,64 @@
+package piper
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// DefaultInterceptShell is the command run by Intercept when none is given.
+var DefaultInterceptShell = []string{"sh"}
+
+// Interceptor opens an interactive shell in a task container. It is
+// implemented by DockerClient and PodmanClient.
+type Interceptor interface {
+	Intercept(name string, shell []string) error
+}
+
+// Intercept opens an interactive shell in the named task container, reading
+// from Stdin. A running container is entered with exec. A stopped container,
+// like one kept with KeepOnFailure, is committed to a snapshot image that the
+// shell is run from with the volumes of the container, so that it has the same
+// mounts, env and workdir as the task. The snapshot is removed afterwards.
+func (c DockerClient) Intercept(name string, shell []string) error {
+	if len(shell) == 0 {
+		shell = DefaultInterceptShell
+	}
+
+	running, err := c.containerRunning(name)
+	if err != nil {
+		return err
+	}
+
+	if running {
+		cmd := c.command(append([]string{"exec", "--interactive", "--tty", name}, shell...)...)
+		cmd.Stdin = c.Stdin
+
+		return exitStatusError(cmd.Run())
+	}
+
+	snapshot := fmt.Sprintf("piper-snapshot:%s", name)
+	err = c.quiet(c.command("commit", name, snapshot)).Run()
+	if err != nil {
+		return fmt.Errorf("could not snapshot container %s: %s", name, err)
+	}
+	defer c.quiet(c.command("rmi", snapshot)).Run()
+
+	cmd := c.command(append([]string{"run", "--rm", "--interactive", "--tty", fmt.Sprintf("--volumes-from=%s", name), snapshot}, shell...)...)
+	cmd.Stdin = c.Stdin
+
+	return exitStatusError(cmd.Run())
+}
+
+func (c DockerClient) containerRunning(name string) (bool, error) {
+	var output bytes.Buffer
+	cmd := c.command("inspect", "--format={{.State.Running}}", name)
+	cmd.Stdout = &output
+
+	err := cmd.Run()
+	if err != nil {
+		return false, fmt.Errorf("could not find container %s: %s", name, err)
+	}
+
+	return strings.TrimSpace(output.String()) == "true", nil
+}
@@ -0,0 +1,101 @@
+package piper_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/ryanmoran/piper"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Intercept", func() {
+	var (
+		client  piper.DockerClient
+		stdout  *bytes.Buffer
+		tempDir string
+		logPath string
+	)
+
+	BeforeEach(func() {
+		var err error
+		tempDir, err = ioutil.TempDir("", "")
+		Expect(err).NotTo(HaveOccurred())
+
+		logPath = filepath.Join(tempDir, "invocations")
+		stdout = bytes.NewBuffer([]byte{})
+
+		client = piper.DockerClient{
+			Command: exec.Command("sh", "-c", `
+				echo "$@" >> "$0"
+				case "$1" in
+					inspect)
+						[ -n "$MISSING" ] && exit 1
+						echo "${RUNNING:-false}"
+						;;
+					exec|run)
+						cat
+						exit "${EXIT_STATUS:-0}"
+						;;
+				esac`, logPath),
+			Stdin:  strings.NewReader("some-input\n"),
+			Stdout: stdout,
+		}
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(tempDir)).To(Succeed())
+	})
+
+	It("opens a shell in a running container", func() {
+		os.Setenv("RUNNING", "true")
+		defer os.Unsetenv("RUNNING")
+
+		err := client.Intercept("piper-some-name", nil)
+		Expect(err).NotTo(HaveOccurred())
+
+		invocations, err := ioutil.ReadFile(logPath)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(invocations)).To(Equal(`inspect --format={{.State.Running}} piper-some-name
+exec --interactive --tty piper-some-name sh
+`))
+		Expect(stdout.String()).To(Equal("some-input\n"))
+	})
+
+	It("opens a shell in a snapshot of a stopped container with its volumes", func() {
+		err := client.Intercept("piper-some-name", []string{"bash", "-l"})
+		Expect(err).NotTo(HaveOccurred())
+
+		invocations, err := ioutil.ReadFile(logPath)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(invocations)).To(Equal(`inspect --format={{.State.Running}} piper-some-name
+commit piper-some-name piper-snapshot:piper-some-name
+run --rm --interactive --tty --volumes-from=piper-some-name piper-snapshot:piper-some-name bash -l
+rmi piper-snapshot:piper-some-name
+`))
+		Expect(stdout.String()).To(Equal("some-input\n"))
+	})
+
+	It("returns the exit status of the shell", func() {
+		os.Setenv("EXIT_STATUS", "4")
+		defer os.Unsetenv("EXIT_STATUS")
+
+		err := client.Intercept("piper-some-name", nil)
+		Expect(err).To(Equal(piper.ContainerExitError{Status: 4}))
+	})
+
+	Context("failure cases", func() {
+		It("returns an error when the container cannot be found", func() {
+			os.Setenv("MISSING", "true")
+			defer os.Unsetenv("MISSING")
+
+			err := client.Intercept("piper-some-name", nil)
+			Expect(err).To(MatchError("could not find container piper-some-name: exit status 1"))
+		})
+	})
+})
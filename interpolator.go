@@ -0,0 +1,260 @@
+package piper
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+var variablePattern = regexp.MustCompile(`\(\(\s*([-/.:\w]+)\s*\)\)`)
+
+// Variables resolves the top-level name of a ((var)). It is implemented both
+// by plain vars and by the credential managers in credentials.go.
+type Variables interface {
+	Get(name string) (value interface{}, found bool, err error)
+}
+
+type StaticVariables map[string]interface{}
+
+func (v StaticVariables) Get(name string) (interface{}, bool, error) {
+	value, ok := v[name]
+	return value, ok, nil
+}
+
+type VariablesBuilder struct{}
+
+// Build loads variables the way fly execute does: each -l file in order,
+// then -y YAML values, then -v string values. Dotted names like creds.user
+// set a field of a nested mapping.
+func (b VariablesBuilder) Build(stringPairs, yamlPairs, files []string) (StaticVariables, error) {
+	variables := StaticVariables{}
+
+	for _, file := range files {
+		contents, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+
+		var fileVariables map[string]interface{}
+		err = yaml.Unmarshal(contents, &fileVariables)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse vars file %s: %s", file, err)
+		}
+
+		for name, value := range fileVariables {
+			variables[name] = value
+		}
+	}
+
+	for _, pair := range yamlPairs {
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("could not parse var %q. must be of form <name>=<yaml-value>", pair)
+		}
+
+		var value interface{}
+		err := yaml.Unmarshal([]byte(parts[1]), &value)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse var %q: %s", pair, err)
+		}
+
+		variables.set(parts[0], value)
+	}
+
+	for _, pair := range stringPairs {
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("could not parse var %q. must be of form <name>=<value>", pair)
+		}
+
+		variables.set(parts[0], parts[1])
+	}
+
+	return variables, nil
+}
+
+func (v StaticVariables) set(name string, value interface{}) {
+	fields := strings.Split(name, ".")
+
+	current := map[string]interface{}(v)
+	for _, field := range fields[:len(fields)-1] {
+		next, ok := current[field].(map[string]interface{})
+		if !ok {
+			next = map[string]interface{}{}
+			current[field] = next
+		}
+		current = next
+	}
+
+	current[fields[len(fields)-1]] = value
+}
+
+type ResolvedVariable struct {
+	Name   string
+	Value  string
+	Secret bool
+}
+
+// Interpolator looks vars up in Variables first and then in Credentials.
+// Values found in Credentials are marked as secret.
+type Interpolator struct {
+	Variables   Variables
+	Credentials Variables
+}
+
+// Interpolate replaces every ((var)) in the document. A scalar that is a
+// single ((var)) takes on the type of the value, while vars embedded in a
+// longer string are rendered into it. It returns the resolved vars in name
+// order, and a ValidationErrors listing the location of every undefined var.
+func (i Interpolator) Interpolate(document *yaml.Node) ([]ResolvedVariable, error) {
+	resolved := map[string]ResolvedVariable{}
+
+	var errs ValidationErrors
+	i.interpolate(document, resolved, &errs)
+	if len(errs) > 0 {
+		return nil, errs
+	}
+
+	var variables []ResolvedVariable
+	for _, variable := range resolved {
+		variables = append(variables, variable)
+	}
+	sort.Slice(variables, func(a, b int) bool {
+		return variables[a].Name < variables[b].Name
+	})
+
+	return variables, nil
+}
+
+func (i Interpolator) interpolate(node *yaml.Node, resolved map[string]ResolvedVariable, errs *ValidationErrors) {
+	if node == nil {
+		return
+	}
+
+	if node.Kind != yaml.ScalarNode {
+		for _, child := range node.Content {
+			i.interpolate(child, resolved, errs)
+		}
+		return
+	}
+
+	matches := variablePattern.FindAllStringSubmatchIndex(node.Value, -1)
+	if len(matches) == 0 {
+		return
+	}
+
+	if len(matches) == 1 && matches[0][0] == 0 && matches[0][1] == len(node.Value) {
+		name := node.Value[matches[0][2]:matches[0][3]]
+		value, ok := i.lookup(node, name, resolved, errs)
+		if !ok {
+			return
+		}
+
+		var replacement yaml.Node
+		err := replacement.Encode(value)
+		if err != nil {
+			*errs = append(*errs, validationError(node, fmt.Sprintf("could not interpolate ((%s)): %s", name, err)))
+			return
+		}
+
+		replacement.Line, replacement.Column = node.Line, node.Column
+		*node = replacement
+		return
+	}
+
+	var interpolated strings.Builder
+	last := 0
+	for _, match := range matches {
+		interpolated.WriteString(node.Value[last:match[0]])
+		last = match[1]
+
+		name := node.Value[match[2]:match[3]]
+		value, ok := i.lookup(node, name, resolved, errs)
+		if !ok {
+			continue
+		}
+
+		switch value.(type) {
+		case map[string]interface{}, map[interface{}]interface{}, []interface{}:
+			*errs = append(*errs, validationError(node, fmt.Sprintf("cannot interpolate ((%s)) into a string: its value is not a primitive", name)))
+			continue
+		}
+
+		interpolated.WriteString(renderVariable(value))
+	}
+	interpolated.WriteString(node.Value[last:])
+
+	node.Value = interpolated.String()
+	node.Tag = "!!str"
+}
+
+func (i Interpolator) lookup(node *yaml.Node, name string, resolved map[string]ResolvedVariable, errs *ValidationErrors) (interface{}, bool) {
+	fields := strings.Split(name, ".")
+
+	var (
+		value  interface{}
+		found  bool
+		secret bool
+		err    error
+	)
+	if i.Variables != nil {
+		value, found, err = i.Variables.Get(fields[0])
+	}
+	if err == nil && !found && i.Credentials != nil {
+		value, found, err = i.Credentials.Get(fields[0])
+		secret = true
+	}
+	if err != nil {
+		*errs = append(*errs, validationError(node, fmt.Sprintf("could not look up ((%s)): %s", name, err)))
+		return nil, false
+	}
+	if !found {
+		*errs = append(*errs, validationError(node, fmt.Sprintf("undefined variable ((%s))", name)))
+		return nil, false
+	}
+
+	for _, field := range fields[1:] {
+		switch typed := value.(type) {
+		case map[string]interface{}:
+			value, found = typed[field]
+		case map[interface{}]interface{}:
+			value, found = typed[field]
+		default:
+			found = false
+		}
+
+		if !found {
+			*errs = append(*errs, validationError(node, fmt.Sprintf("undefined variable ((%s)): missing field %q", name, field)))
+			return nil, false
+		}
+	}
+
+	resolved[name] = ResolvedVariable{
+		Name:   name,
+		Value:  renderVariable(value),
+		Secret: secret,
+	}
+	return value, true
+}
+
+func renderVariable(value interface{}) string {
+	switch typed := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return typed
+	case map[string]interface{}, map[interface{}]interface{}, []interface{}:
+		rendered, err := json.Marshal(jsonCompatible(typed))
+		if err != nil {
+			return fmt.Sprint(typed)
+		}
+		return string(rendered)
+	default:
+		return fmt.Sprint(typed)
+	}
+}
@@ -0,0 +1,193 @@
+package piper_test
+
+import (
+	"io/ioutil"
+	"os"
+
+	"github.com/ryanmoran/piper"
+	"gopkg.in/yaml.v3"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Interpolator", func() {
+	var interpolate = func(interpolator piper.Interpolator, contents string) (map[string]interface{}, []piper.ResolvedVariable, error) {
+		var document yaml.Node
+		err := yaml.Unmarshal([]byte(contents), &document)
+		Expect(err).NotTo(HaveOccurred())
+
+		variables, err := interpolator.Interpolate(&document)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		var result map[string]interface{}
+		Expect(document.Decode(&result)).To(Succeed())
+
+		return result, variables, nil
+	}
+
+	It("replaces whole values with the typed value of the var", func() {
+		result, variables, err := interpolate(piper.Interpolator{
+			Variables: piper.StaticVariables{
+				"tag":     "1.7",
+				"retries": 3,
+				"config":  map[string]interface{}{"a": "b"},
+			},
+		}, `{tag: ((tag)), retries: (( retries )), config: ((config))}`)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(result).To(Equal(map[string]interface{}{
+			"tag":     "1.7",
+			"retries": 3,
+			"config":  map[string]interface{}{"a": "b"},
+		}))
+		Expect(variables).To(Equal([]piper.ResolvedVariable{
+			{Name: "config", Value: `{"a":"b"}`},
+			{Name: "retries", Value: "3"},
+			{Name: "tag", Value: "1.7"},
+		}))
+	})
+
+	It("renders vars embedded in a string", func() {
+		result, _, err := interpolate(piper.Interpolator{
+			Variables: piper.StaticVariables{
+				"repository": "some-repo/some-image",
+				"tag":        3,
+			},
+		}, `{image: "docker:///((repository))#((tag))"}`)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(result).To(Equal(map[string]interface{}{
+			"image": "docker:///some-repo/some-image#3",
+		}))
+	})
+
+	It("accesses nested fields", func() {
+		result, variables, err := interpolate(piper.Interpolator{
+			Variables: piper.StaticVariables{
+				"creds": map[string]interface{}{
+					"user": "some-user",
+				},
+			},
+		}, `{user: ((creds.user))}`)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(result).To(Equal(map[string]interface{}{"user": "some-user"}))
+		Expect(variables).To(Equal([]piper.ResolvedVariable{
+			{Name: "creds.user", Value: "some-user"},
+		}))
+	})
+
+	It("marks values found in the credentials as secret", func() {
+		result, variables, err := interpolate(piper.Interpolator{
+			Variables:   piper.StaticVariables{"user": "some-user"},
+			Credentials: piper.StaticVariables{"user": "other-user", "password": "some-password"},
+		}, `{user: ((user)), password: ((password))}`)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(result).To(Equal(map[string]interface{}{
+			"user":     "some-user",
+			"password": "some-password",
+		}))
+		Expect(variables).To(Equal([]piper.ResolvedVariable{
+			{Name: "password", Value: "some-password", Secret: true},
+			{Name: "user", Value: "some-user"},
+		}))
+	})
+
+	Context("failure cases", func() {
+		It("reports every undefined var with its location", func() {
+			_, _, err := interpolate(piper.Interpolator{
+				Variables: piper.StaticVariables{
+					"creds": map[string]interface{}{"user": "some-user"},
+				},
+			}, `---
+image: ((image))
+params:
+  PASSWORD: prefix-((creds.password))
+`)
+			Expect(err).To(Equal(piper.ValidationErrors{
+				{Line: 2, Column: 8, Message: "undefined variable ((image))"},
+				{Line: 4, Column: 13, Message: `undefined variable ((creds.password)): missing field "password"`},
+			}))
+		})
+
+		It("reports undefined vars when there are no variables", func() {
+			_, _, err := interpolate(piper.Interpolator{}, `{image: ((image))}`)
+			Expect(err).To(MatchError("line 1, column 9: undefined variable ((image))"))
+		})
+
+		It("rejects interpolating a mapping into a string", func() {
+			_, _, err := interpolate(piper.Interpolator{
+				Variables: piper.StaticVariables{"config": map[string]interface{}{"a": "b"}},
+			}, `{value: "prefix-((config))"}`)
+			Expect(err).To(MatchError("line 1, column 9: cannot interpolate ((config)) into a string: its value is not a primitive"))
+		})
+	})
+})
+
+var _ = Describe("VariablesBuilder", func() {
+	var varsFilePath string
+
+	BeforeEach(func() {
+		tempFile, err := ioutil.TempFile("", "")
+		Expect(err).NotTo(HaveOccurred())
+
+		_, err = tempFile.WriteString(`---
+image: file-image
+tag: file-tag
+creds:
+  user: file-user
+`)
+		Expect(err).NotTo(HaveOccurred())
+
+		varsFilePath = tempFile.Name()
+
+		err = tempFile.Close()
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		err := os.RemoveAll(varsFilePath)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("loads vars files, then yaml vars, then string vars", func() {
+		variables, err := piper.VariablesBuilder{}.Build(
+			[]string{"tag=string-tag", "creds.password=some=password"},
+			[]string{"tag=1.7", "retries=3", "list=[a, b]"},
+			[]string{varsFilePath},
+		)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(variables).To(Equal(piper.StaticVariables{
+			"image":   "file-image",
+			"tag":     "string-tag",
+			"retries": 3,
+			"list":    []interface{}{"a", "b"},
+			"creds": map[string]interface{}{
+				"user":     "file-user",
+				"password": "some=password",
+			},
+		}))
+	})
+
+	Context("failure cases", func() {
+		It("returns an error for a malformed var", func() {
+			_, err := piper.VariablesBuilder{}.Build([]string{"some-var"}, nil, nil)
+			Expect(err).To(MatchError(`could not parse var "some-var". must be of form <name>=<value>`))
+		})
+
+		It("returns an error for a malformed yaml var", func() {
+			_, err := piper.VariablesBuilder{}.Build(nil, []string{"some-var=[a"}, nil)
+			Expect(err).To(MatchError(ContainSubstring(`could not parse var "some-var=[a"`)))
+		})
+
+		It("returns an error for a missing vars file", func() {
+			_, err := piper.VariablesBuilder{}.Build(nil, nil, []string{"no-such-file"})
+			Expect(err).To(MatchError(ContainSubstring("no such file or directory")))
+		})
+	})
+})
@@ -1,11 +1,18 @@
 package piper
 
 import (
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"math"
+	"net/url"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
 	"strings"
 
-	"gopkg.in/yaml.v2"
+	"gopkg.in/yaml.v3"
 )
 
 type VolumeMount struct {
@@ -17,36 +24,168 @@ type VolumeMount struct {
 type Run struct {
 	Path string   `yaml:"path"`
 	Args []string `yaml:"args"`
-	dir  string   `yaml:"dir"`
+	Dir  string   `yaml:"dir"`
+	User string   `yaml:"user"`
 }
 
-type ImageResourceSource struct {
-	Repository string
-	Tag        string
+func (r Run) WorkingDirectory() (string, error) {
+	workdir := filepath.Join(VolumeMountPoint, r.Dir)
+	if workdir != VolumeMountPoint && !strings.HasPrefix(workdir, VolumeMountPoint+"/") {
+		return "", fmt.Errorf("run.dir %q must be inside of %s", r.Dir, VolumeMountPoint)
+	}
+
+	return workdir, nil
 }
 
-func (i ImageResourceSource) String() string {
-	if "" != i.Tag {
-		return fmt.Sprintf("%s:%s", i.Repository, i.Tag)
+var memoryLimitPattern = regexp.MustCompile(`^(?i)\s*(\d+)\s*(b|kb|mb|gb)?\s*$`)
+
+type MemoryLimit uint64
+
+func ParseMemoryLimit(limit string) (MemoryLimit, error) {
+	matches := memoryLimitPattern.FindStringSubmatch(limit)
+	if matches == nil {
+		return 0, fmt.Errorf("could not parse memory limit %q. must be a number of bytes with an optional KB, MB or GB unit", limit)
+	}
+
+	value, err := strconv.ParseUint(matches[1], 10, 64)
+	if err != nil {
+		return 0, err
 	}
-	return i.Repository
+
+	var multiplier uint64 = 1
+	switch strings.ToLower(matches[2]) {
+	case "kb":
+		multiplier = 1024
+	case "mb":
+		multiplier = 1024 * 1024
+	case "gb":
+		multiplier = 1024 * 1024 * 1024
+	}
+
+	if value > math.MaxUint64/multiplier {
+		return 0, fmt.Errorf("memory limit %q is too large", limit)
+	}
+
+	return MemoryLimit(value * multiplier), nil
 }
 
-type ImageResource struct {
-	Source ImageResourceSource
+func (m *MemoryLimit) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var limit string
+	err := unmarshal(&limit)
+	if err != nil {
+		return err
+	}
+
+	*m, err = ParseMemoryLimit(limit)
+	return err
+}
+
+type ContainerLimits struct {
+	CPU    uint64      `yaml:"cpu"`
+	Memory MemoryLimit `yaml:"memory"`
 }
 
 type Task struct {
-	Image         string `yaml:"image"`
-	Run           Run
-	Inputs        []VolumeMount
-	Outputs       []VolumeMount
-	Caches        []VolumeMount
-	Params        map[string]string
-	ImageResource ImageResource `yaml:"image_resource"`
+	Image           string `yaml:"image"`
+	RootfsURI       string `yaml:"rootfs_uri"`
+	Run             Run
+	Inputs          []VolumeMount
+	Outputs         []VolumeMount
+	Caches          []VolumeMount
+	Params          map[string]string `yaml:"-"`
+	ImageResource   ImageResource     `yaml:"image_resource"`
+	ContainerLimits ContainerLimits   `yaml:"container_limits"`
+
+	// Variables lists the ((vars)) that were interpolated into the config.
+	Variables []ResolvedVariable `yaml:"-"`
+}
+
+func (t *Task) UnmarshalYAML(value *yaml.Node) error {
+	type task Task
+	err := value.Decode((*task)(t))
+	if err != nil {
+		return err
+	}
+
+	t.Params, err = renderParams(mappingValue(value, "params"))
+	return err
 }
 
-type Parser struct{}
+// renderParams converts params to environment variable values the way
+// Concourse does: scalars keep their literal text, null becomes an empty
+// string, and mappings and sequences are rendered as JSON.
+func renderParams(node *yaml.Node) (map[string]string, error) {
+	if node == nil || node.Tag == "!!null" {
+		return nil, nil
+	}
+
+	if node.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("line %d: params must be a mapping", node.Line)
+	}
+
+	params := make(map[string]string)
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i].Value, node.Content[i+1]
+		for value.Kind == yaml.AliasNode {
+			value = value.Alias
+		}
+
+		switch {
+		case value.Tag == "!!null":
+			params[key] = ""
+		case value.Kind == yaml.ScalarNode:
+			params[key] = value.Value
+		default:
+			var decoded interface{}
+			err := value.Decode(&decoded)
+			if err != nil {
+				return nil, err
+			}
+
+			rendered, err := json.Marshal(jsonCompatible(decoded))
+			if err != nil {
+				return nil, fmt.Errorf("line %d: could not render param %q as JSON: %s", value.Line, key, err)
+			}
+			params[key] = string(rendered)
+		}
+	}
+
+	return params, nil
+}
+
+// jsonCompatible converts the map[interface{}]interface{} values that YAML
+// produces for mappings with non-string keys into values encoding/json accepts.
+func jsonCompatible(value interface{}) interface{} {
+	switch typed := value.(type) {
+	case map[interface{}]interface{}:
+		converted := make(map[string]interface{})
+		for key, item := range typed {
+			converted[fmt.Sprint(key)] = jsonCompatible(item)
+		}
+		return converted
+	case map[string]interface{}:
+		for key, item := range typed {
+			typed[key] = jsonCompatible(item)
+		}
+		return typed
+	case []interface{}:
+		for i, item := range typed {
+			typed[i] = jsonCompatible(item)
+		}
+		return typed
+	default:
+		return value
+	}
+}
+
+type Parser struct {
+	Variables   Variables
+	Credentials Variables
+
+	// ImageGiven is set when the image is given outside the task config, so
+	// that a task config without one is valid.
+	ImageGiven bool
+}
 
 func (p Parser) Parse(path string) (Task, error) {
 	contents, err := ioutil.ReadFile(path)
@@ -54,17 +193,97 @@ func (p Parser) Parse(path string) (Task, error) {
 		return Task{}, err
 	}
 
-	var task Task
+	return p.ParseContents(contents)
+}
+
+func (p Parser) ParseContents(contents []byte) (Task, error) {
+	var document yaml.Node
+	err := yaml.Unmarshal(contents, &document)
+	if err != nil {
+		return Task{}, err
+	}
+
+	return p.ParseNode(&document)
+}
 
-	err = yaml.Unmarshal(contents, &task)
+// ParseNode interpolates and parses a task config that has already been read
+// as YAML, such as the inline config of a pipeline task step, so that errors
+// keep the line numbers of the original file.
+func (p Parser) ParseNode(document *yaml.Node) (Task, error) {
+	variables, err := Interpolator{Variables: p.Variables, Credentials: p.Credentials}.Interpolate(document)
 	if err != nil {
 		return Task{}, err
 	}
-	if task.ImageResource.Source.Repository != "" {
-		task.Image = task.ImageResource.Source.String()
+
+	err = Validator{ImageGiven: p.ImageGiven}.Validate(document)
+	if err != nil {
+		return Task{}, err
+	}
+
+	var task Task
+	err = document.Decode(&task)
+	if err != nil {
+		return Task{}, err
+	}
+	task.Variables = variables
+
+	if task.ImageResource.Type != "" || task.ImageResource.Source.Repository != "" {
+		task.Image, err = task.ImageResource.Image()
+		if err != nil {
+			return Task{}, err
+		}
 	} else {
-		task.Image = strings.TrimPrefix(task.Image, "docker:///")
+		image := task.Image
+		if image == "" {
+			image = task.RootfsURI
+		}
+
+		task.Image, err = NormalizeImage(image)
+		if err != nil {
+			return Task{}, err
+		}
 	}
 
 	return task, nil
 }
+
+// NormalizeImage converts the image reference forms Concourse has accepted,
+// such as docker:///repository#tag and docker://registry/repository, into a
+// plain docker image reference.
+func NormalizeImage(image string) (string, error) {
+	if !strings.Contains(image, "://") {
+		return image, nil
+	}
+
+	imageURL, err := url.Parse(image)
+	if err != nil {
+		return "", fmt.Errorf("cannot map image %q to a docker image: %s", image, err)
+	}
+
+	if imageURL.Scheme != "docker" {
+		return "", fmt.Errorf("cannot map image %q to a docker image: unsupported scheme %q", image, imageURL.Scheme)
+	}
+
+	if imageURL.RawQuery != "" || imageURL.User != nil {
+		return "", fmt.Errorf("cannot map image %q to a docker image: unexpected query or credentials", image)
+	}
+
+	repository := strings.Trim(imageURL.Path, "/")
+	if repository == "" {
+		return "", fmt.Errorf("cannot map image %q to a docker image: missing repository", image)
+	}
+
+	if imageURL.Host != "" {
+		repository = fmt.Sprintf("%s/%s", imageURL.Host, repository)
+	}
+
+	if imageURL.Fragment != "" {
+		if strings.Contains(path.Base(repository), ":") || strings.Contains(repository, "@") {
+			return "", fmt.Errorf("cannot map image %q to a docker image: tag given in both the reference and the fragment", image)
+		}
+
+		repository = fmt.Sprintf("%s:%s", repository, imageURL.Fragment)
+	}
+
+	return repository, nil
+}
@@ -7,6 +7,7 @@ import (
 	"github.com/ryanmoran/piper"
 
 	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
 	. "github.com/onsi/gomega"
 )
 
@@ -26,6 +27,8 @@ image: docker:///some-docker-image
 run:
   path: /path/to/run/command
   args: ['-arg1', '-arg2']
+  dir: some-dir
+  user: some-user
 inputs:
   - name: input-1
   - name: input-2
@@ -41,6 +44,9 @@ caches:
 params:
   VAR1: var-1
   VAR2: var-2
+container_limits:
+  cpu: 512
+  memory: 1073741824
 `)
 			Expect(err).NotTo(HaveOccurred())
 
@@ -68,6 +74,8 @@ params:
 
 			Expect(config.Run.Path).To(Equal("/path/to/run/command"))
 			Expect(config.Run.Args).To(Equal([]string{"-arg1", "-arg2"}))
+			Expect(config.Run.Dir).To(Equal("some-dir"))
+			Expect(config.Run.User).To(Equal("some-user"))
 		})
 
 		It("parses the task config for the inputs", func() {
@@ -113,6 +121,75 @@ params:
 			}))
 		})
 
+		DescribeTable("renders non-string params the way Concourse does",
+			func(param, expected string) {
+				err := ioutil.WriteFile(configFilePath, []byte(`---
+image: some-image
+run:
+  path: /path/to/run/command
+params:
+  PARAM: `+param+`
+`), 0644)
+				Expect(err).NotTo(HaveOccurred())
+
+				config, err := parser.Parse(configFilePath)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(config.Params).To(Equal(map[string]string{"PARAM": expected}))
+			},
+			Entry("a string", "some-value", "some-value"),
+			Entry("a quoted string", `"3"`, "3"),
+			Entry("an integer", "3", "3"),
+			Entry("a float", "1.5", "1.5"),
+			Entry("a boolean", "true", "true"),
+			Entry("a null", "", ""),
+			Entry("a mapping", "{a: b, c: 1}", `{"a":"b","c":1}`),
+			Entry("a nested mapping", "{a: {b: [1, true, null]}}", `{"a":{"b":[1,true,null]}}`),
+			Entry("a mapping with non-string keys", "{1: one}", `{"1":"one"}`),
+			Entry("a sequence", "[a, 2, false]", `["a",2,false]`),
+			Entry("a multiline string", "|\n    line-1\n    line-2", "line-1\nline-2\n"),
+		)
+
+		It("parses the task config for the container limits", func() {
+			config, err := parser.Parse(configFilePath)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(config.ContainerLimits).To(Equal(piper.ContainerLimits{
+				CPU:    512,
+				Memory: 1073741824,
+			}))
+		})
+
+		It("parses container memory limits with units", func() {
+			err := ioutil.WriteFile(configFilePath, []byte(`---
+image: docker:///some-docker-image
+run:
+  path: /path/to/run/command
+container_limits:
+  memory: 512MB
+`), 0644)
+			Expect(err).NotTo(HaveOccurred())
+
+			config, err := parser.Parse(configFilePath)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(config.ContainerLimits.Memory).To(Equal(piper.MemoryLimit(512 * 1024 * 1024)))
+		})
+
+		It("honors the deprecated rootfs_uri", func() {
+			err := ioutil.WriteFile(configFilePath, []byte(`---
+rootfs_uri: docker:///some-docker-image#some-tag
+run:
+  path: /path/to/run/command
+`), 0644)
+			Expect(err).NotTo(HaveOccurred())
+
+			config, err := parser.Parse(configFilePath)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(config.Image).To(Equal("some-docker-image:some-tag"))
+		})
+
 		It("honors the image_resource", func() {
 			tempFile, err := ioutil.TempFile("", "")
 			Expect(err).NotTo(HaveOccurred())
@@ -174,7 +251,99 @@ params:
 			Expect(config.Image).To(Equal("repo/docker-image-name:1.7"))
 		})
 
+		It("parses the full image_resource", func() {
+			err := ioutil.WriteFile(configFilePath, []byte(`---
+image_resource:
+  type: registry-image
+  source:
+    repository: registry.example.com/some-image
+    tag: some-tag
+    username: some-user
+    password: some-password
+    insecure_registries: [registry.example.com]
+    registry_mirror:
+      host: mirror.example.com
+      username: mirror-user
+      password: mirror-password
+  params:
+    format: oci
+  version:
+    digest: sha256:some-digest
+run:
+  path: /path/to/run/command
+`), 0644)
+			Expect(err).NotTo(HaveOccurred())
+
+			config, err := parser.Parse(configFilePath)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(config.ImageResource).To(Equal(piper.ImageResource{
+				Type: "registry-image",
+				Source: piper.ImageResourceSource{
+					Repository:         "registry.example.com/some-image",
+					Tag:                "some-tag",
+					Username:           "some-user",
+					Password:           "some-password",
+					InsecureRegistries: []string{"registry.example.com"},
+					RegistryMirror: piper.RegistryMirror{
+						Host:     "mirror.example.com",
+						Username: "mirror-user",
+						Password: "mirror-password",
+					},
+				},
+				Params:  map[string]interface{}{"format": "oci"},
+				Version: piper.ImageResourceVersion{Digest: "sha256:some-digest"},
+			}))
+			Expect(config.Image).To(Equal("registry.example.com/some-image@sha256:some-digest"))
+		})
+
+		It("parses a docker-image registry_mirror url", func() {
+			err := ioutil.WriteFile(configFilePath, []byte(`---
+image_resource:
+  type: docker-image
+  source:
+    repository: some-image
+    registry_mirror: https://mirror.example.com
+run:
+  path: /path/to/run/command
+`), 0644)
+			Expect(err).NotTo(HaveOccurred())
+
+			config, err := parser.Parse(configFilePath)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(config.ImageResource.Source.RegistryMirror).To(Equal(piper.RegistryMirror{Host: "mirror.example.com"}))
+			Expect(config.Image).To(Equal("mirror.example.com/library/some-image"))
+		})
+
 		Context("failure cases", func() {
+			Context("when the image_resource type is not supported", func() {
+				It("returns an error", func() {
+					err := ioutil.WriteFile(configFilePath, []byte(`---
+image_resource:
+  type: git
+  source:
+    repository: some-image
+run:
+  path: /path/to/run/command
+`), 0644)
+					Expect(err).NotTo(HaveOccurred())
+
+					_, err = parser.Parse(configFilePath)
+					Expect(err).To(MatchError(`unsupported image_resource type "git": must be one of docker-image, registry-image`))
+				})
+			})
+
+			Context("when the image cannot be mapped to a docker image", func() {
+				It("returns an error", func() {
+					err := ioutil.WriteFile(configFilePath, []byte("{image: raw:///some/rootfs, run: {path: some-command}}"), 0644)
+					Expect(err).NotTo(HaveOccurred())
+
+					_, err = parser.Parse(configFilePath)
+					Expect(err).To(MatchError(`cannot map image "raw:///some/rootfs" to a docker image: unsupported scheme "raw"`))
+				})
+			})
+
 			Context("when the task file does not exist", func() {
 				It("returns an error", func() {
 					err := os.RemoveAll(configFilePath)
@@ -194,6 +363,83 @@ params:
 					Expect(err).To(MatchError(ContainSubstring("could not find expected directive name")))
 				})
 			})
+
+			Context("when the container memory limit is not valid", func() {
+				It("returns an error", func() {
+					err := ioutil.WriteFile(configFilePath, []byte("{image: some-image, run: {path: some-command}, container_limits: {memory: lots}}"), 0644)
+					Expect(err).NotTo(HaveOccurred())
+
+					_, err = parser.Parse(configFilePath)
+					Expect(err).To(MatchError(`could not parse memory limit "lots". must be a number of bytes with an optional KB, MB or GB unit`))
+				})
+			})
+
+			Context("when the container memory limit is too large", func() {
+				It("returns an error", func() {
+					err := ioutil.WriteFile(configFilePath, []byte("{image: some-image, run: {path: some-command}, container_limits: {memory: 20000000000GB}}"), 0644)
+					Expect(err).NotTo(HaveOccurred())
+
+					_, err = parser.Parse(configFilePath)
+					Expect(err).To(MatchError(`memory limit "20000000000GB" is too large`))
+				})
+			})
 		})
 	})
 })
+
+var _ = Describe("Run", func() {
+	Describe("WorkingDirectory", func() {
+		It("resolves the dir against the volume mount point", func() {
+			workdir, err := piper.Run{Dir: "some/dir"}.WorkingDirectory()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(workdir).To(Equal("/tmp/build/some/dir"))
+		})
+
+		It("defaults to the volume mount point", func() {
+			workdir, err := piper.Run{}.WorkingDirectory()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(workdir).To(Equal("/tmp/build"))
+		})
+
+		Context("failure cases", func() {
+			Context("when the dir is outside of the volume mount point", func() {
+				It("returns an error", func() {
+					_, err := piper.Run{Dir: "../some-dir"}.WorkingDirectory()
+					Expect(err).To(MatchError(`run.dir "../some-dir" must be inside of /tmp/build`))
+				})
+			})
+		})
+	})
+})
+
+var _ = Describe("NormalizeImage", func() {
+	DescribeTable("maps image references to docker references",
+		func(image, expected string) {
+			reference, err := piper.NormalizeImage(image)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(reference).To(Equal(expected))
+		},
+		Entry("an empty image", "", ""),
+		Entry("a plain repository", "some-image", "some-image"),
+		Entry("a plain repository with a tag", "some-repo/some-image:some-tag", "some-repo/some-image:some-tag"),
+		Entry("a plain repository with a registry", "localhost:5000/some-image", "localhost:5000/some-image"),
+		Entry("a plain repository with a digest", "some-image@sha256:some-digest", "some-image@sha256:some-digest"),
+		Entry("a docker:/// repository", "docker:///some-image", "some-image"),
+		Entry("a docker:/// repository with a tag", "docker:///some-repo/some-image:some-tag", "some-repo/some-image:some-tag"),
+		Entry("a docker:/// repository with a fragment tag", "docker:///some-repo/some-image#some-tag", "some-repo/some-image:some-tag"),
+		Entry("a docker:// registry", "docker://registry.example.com/some-image", "registry.example.com/some-image"),
+		Entry("a docker:// registry with a port and fragment tag", "docker://registry.example.com:5000/some-repo/some-image#some-tag", "registry.example.com:5000/some-repo/some-image:some-tag"),
+	)
+
+	DescribeTable("rejects image references that cannot be mapped",
+		func(image, message string) {
+			_, err := piper.NormalizeImage(image)
+			Expect(err).To(MatchError(message))
+		},
+		Entry("an unsupported scheme", "raw:///some/rootfs", `cannot map image "raw:///some/rootfs" to a docker image: unsupported scheme "raw"`),
+		Entry("a missing repository", "docker:///", `cannot map image "docker:///" to a docker image: missing repository`),
+		Entry("a registry without a repository", "docker://registry.example.com", `cannot map image "docker://registry.example.com" to a docker image: missing repository`),
+		Entry("a tag in both places", "docker:///some-image:some-tag#other-tag", `cannot map image "docker:///some-image:some-tag#other-tag" to a docker image: tag given in both the reference and the fragment`),
+		Entry("a query string", "docker:///some-image?some=query", `cannot map image "docker:///some-image?some=query" to a docker image: unexpected query or credentials`),
+	)
+})
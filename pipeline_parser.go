@@ -0,0 +1,171 @@
+package piper
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+type PipelineTask struct {
+	Config        *yaml.Node
+	File          string
+	Params        map[string]string
+	Privileged    bool
+	InputMapping  map[string]string
+	OutputMapping map[string]string
+
+	// Variables lists the ((vars)) that were interpolated into the params.
+	Variables []ResolvedVariable
+}
+
+// TaskFilePath resolves the file of a task step on the local filesystem. The
+// first path segment names an artifact, which is looked up in the given
+// <input-name>=<input-location> pairs and otherwise taken to be a directory
+// relative to the working directory.
+func (t PipelineTask) TaskFilePath(inputs []string) (string, error) {
+	parts := strings.SplitN(filepath.ToSlash(t.File), "/", 2)
+	if len(parts) != 2 {
+		return t.File, nil
+	}
+
+	for _, input := range inputs {
+		pair := strings.Split(input, "=")
+		if len(pair) == 2 && pair[0] == parts[0] {
+			location, err := expandUser(pair[1])
+			if err != nil {
+				return "", err
+			}
+
+			return filepath.Join(location, parts[1]), nil
+		}
+	}
+
+	return t.File, nil
+}
+
+type pipelineTaskStep struct {
+	File          string            `yaml:"file"`
+	Config        yaml.Node         `yaml:"config"`
+	Params        yaml.Node         `yaml:"params"`
+	Privileged    bool              `yaml:"privileged"`
+	InputMapping  map[string]string `yaml:"input_mapping"`
+	OutputMapping map[string]string `yaml:"output_mapping"`
+}
+
+type PipelineParser struct {
+	Variables   Variables
+	Credentials Variables
+}
+
+func (p PipelineParser) Parse(path, jobName, taskName string) (PipelineTask, error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return PipelineTask{}, err
+	}
+
+	var document yaml.Node
+	err = yaml.Unmarshal(contents, &document)
+	if err != nil {
+		return PipelineTask{}, err
+	}
+
+	var job *yaml.Node
+	if len(document.Content) > 0 {
+		jobs := mappingValue(document.Content[0], "jobs")
+		if jobs != nil && jobs.Kind == yaml.SequenceNode {
+			for _, candidate := range jobs.Content {
+				if scalarValue(mappingValue(candidate, "name")) == jobName {
+					job = candidate
+					break
+				}
+			}
+		}
+	}
+	if job == nil {
+		return PipelineTask{}, fmt.Errorf("could not find job %q in pipeline %s", jobName, path)
+	}
+
+	steps := findTaskSteps(mappingValue(job, "plan"), taskName)
+	switch len(steps) {
+	case 0:
+		return PipelineTask{}, fmt.Errorf("could not find task %q in job %q", taskName, jobName)
+	case 1:
+	default:
+		return PipelineTask{}, fmt.Errorf("found %d tasks named %q in job %q", len(steps), taskName, jobName)
+	}
+
+	var step pipelineTaskStep
+	err = steps[0].Decode(&step)
+	if err != nil {
+		return PipelineTask{}, err
+	}
+
+	var config *yaml.Node
+	if step.Config.Kind != 0 {
+		config = &step.Config
+	}
+
+	if config == nil && step.File == "" {
+		return PipelineTask{}, fmt.Errorf("task %q in job %q has neither a config nor a file", taskName, jobName)
+	}
+
+	var (
+		params    map[string]string
+		variables []ResolvedVariable
+	)
+	if step.Params.Kind != 0 {
+		variables, err = Interpolator{Variables: p.Variables, Credentials: p.Credentials}.Interpolate(&step.Params)
+		if err != nil {
+			return PipelineTask{}, err
+		}
+
+		params, err = renderParams(&step.Params)
+		if err != nil {
+			return PipelineTask{}, err
+		}
+	}
+
+	return PipelineTask{
+		Config:        config,
+		File:          step.File,
+		Params:        params,
+		Privileged:    step.Privileged,
+		InputMapping:  step.InputMapping,
+		OutputMapping: step.OutputMapping,
+		Variables:     variables,
+	}, nil
+}
+
+// findTaskSteps walks a job plan, including steps nested in do, in_parallel,
+// try and hooks, for task steps with the given name.
+func findTaskSteps(node *yaml.Node, taskName string) []*yaml.Node {
+	if node == nil {
+		return nil
+	}
+
+	var steps []*yaml.Node
+	switch node.Kind {
+	case yaml.SequenceNode:
+		for _, item := range node.Content {
+			steps = append(steps, findTaskSteps(item, taskName)...)
+		}
+	case yaml.MappingNode:
+		if task := mappingValue(node, "task"); task != nil && task.Kind == yaml.ScalarNode {
+			if task.Value == taskName {
+				steps = append(steps, node)
+			}
+		}
+
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if node.Content[i].Value == "config" || node.Content[i].Value == "params" {
+				continue
+			}
+			steps = append(steps, findTaskSteps(node.Content[i+1], taskName)...)
+		}
+	}
+
+	return steps
+}
@@ -0,0 +1,147 @@
+package piper_test
+
+import (
+	"io/ioutil"
+	"os"
+
+	"github.com/ryanmoran/piper"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("PipelineParser", func() {
+	Describe("Parse", func() {
+		var (
+			pipelineFilePath string
+			parser           piper.PipelineParser
+		)
+
+		BeforeEach(func() {
+			tempFile, err := ioutil.TempFile("", "")
+			Expect(err).NotTo(HaveOccurred())
+
+			_, err = tempFile.WriteString(`---
+jobs:
+  - name: other-job
+    plan:
+      - task: inline-task
+        file: other/task.yml
+  - name: some-job
+    plan:
+      - get: repo
+      - do:
+          - task: inline-task
+            privileged: true
+            params:
+              VAR1: step-var-1
+            config:
+              image: some-image
+              run:
+                path: some-command
+        on_failure:
+          task: file-task
+          file: repo/ci/task.yml
+          input_mapping: {input-1: repo}
+          output_mapping: {output-1: built-repo}
+      - task: empty-task
+      - task: duplicate-task
+        file: repo/task-1.yml
+      - try:
+          task: duplicate-task
+          file: repo/task-2.yml
+`)
+			Expect(err).NotTo(HaveOccurred())
+
+			pipelineFilePath = tempFile.Name()
+
+			err = tempFile.Close()
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		AfterEach(func() {
+			err := os.RemoveAll(pipelineFilePath)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("extracts the inline config of a nested task step", func() {
+			pipelineTask, err := parser.Parse(pipelineFilePath, "some-job", "inline-task")
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(pipelineTask.File).To(BeEmpty())
+			Expect(pipelineTask.Params).To(Equal(map[string]string{"VAR1": "step-var-1"}))
+			Expect(pipelineTask.Privileged).To(BeTrue())
+
+			task, err := piper.Parser{}.ParseNode(pipelineTask.Config)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(task.Image).To(Equal("some-image"))
+			Expect(task.Run.Path).To(Equal("some-command"))
+		})
+
+		It("extracts the file of a task step in a hook", func() {
+			pipelineTask, err := parser.Parse(pipelineFilePath, "some-job", "file-task")
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(pipelineTask.Config).To(BeNil())
+			Expect(pipelineTask.File).To(Equal("repo/ci/task.yml"))
+			Expect(pipelineTask.InputMapping).To(Equal(map[string]string{"input-1": "repo"}))
+			Expect(pipelineTask.OutputMapping).To(Equal(map[string]string{"output-1": "built-repo"}))
+		})
+
+		Context("failure cases", func() {
+			Context("when the pipeline file does not exist", func() {
+				It("returns an error", func() {
+					_, err := parser.Parse("no-such-file", "some-job", "inline-task")
+					Expect(err).To(MatchError(ContainSubstring("no such file or directory")))
+				})
+			})
+
+			Context("when the job does not exist", func() {
+				It("returns an error", func() {
+					_, err := parser.Parse(pipelineFilePath, "no-such-job", "inline-task")
+					Expect(err).To(MatchError(ContainSubstring(`could not find job "no-such-job" in pipeline`)))
+				})
+			})
+
+			Context("when the task does not exist", func() {
+				It("returns an error", func() {
+					_, err := parser.Parse(pipelineFilePath, "some-job", "no-such-task")
+					Expect(err).To(MatchError(`could not find task "no-such-task" in job "some-job"`))
+				})
+			})
+
+			Context("when the task has neither a config nor a file", func() {
+				It("returns an error", func() {
+					_, err := parser.Parse(pipelineFilePath, "some-job", "empty-task")
+					Expect(err).To(MatchError(`task "empty-task" in job "some-job" has neither a config nor a file`))
+				})
+			})
+
+			Context("when more than one task has the name", func() {
+				It("returns an error", func() {
+					_, err := parser.Parse(pipelineFilePath, "some-job", "duplicate-task")
+					Expect(err).To(MatchError(`found 2 tasks named "duplicate-task" in job "some-job"`))
+				})
+			})
+		})
+	})
+})
+
+var _ = Describe("PipelineTask", func() {
+	Describe("TaskFilePath", func() {
+		It("resolves the artifact against the given inputs", func() {
+			path, err := piper.PipelineTask{File: "repo/ci/task.yml"}.TaskFilePath([]string{
+				"other=/some/other/path",
+				"repo=/some/path",
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(path).To(Equal("/some/path/ci/task.yml"))
+		})
+
+		It("falls back to the working directory", func() {
+			path, err := piper.PipelineTask{File: "repo/ci/task.yml"}.TaskFilePath(nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(path).To(Equal("repo/ci/task.yml"))
+		})
+	})
+})
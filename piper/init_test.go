@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
@@ -36,3 +37,9 @@ var _ = BeforeSuite(func() {
 var _ = AfterSuite(func() {
 	gexec.CleanupBuildArtifacts()
 })
+
+// splitInvocations splits the invocations recorded by the fake docker into
+// commands.
+func splitInvocations(invocations []byte) []string {
+	return strings.Split(strings.TrimSpace(string(invocations)), "\n")
+}
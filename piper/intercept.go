@@ -0,0 +1,56 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/ryanmoran/piper"
+)
+
+// intercept opens a shell in a task container, like fly intercept. The
+// container is either still running the task, or was kept with
+// -keep-on-failure.
+func intercept(args []string) {
+	var runtimeName string
+
+	flags := flag.NewFlagSet("piper intercept", flag.ContinueOnError)
+	flags.StringVar(&runtimeName, "runtime", "", fmt.Sprintf("container runtime CLI the task was run with, one of %s (default the first found on the $PATH)", strings.Join(piper.Runtimes, ", ")))
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: piper intercept [-runtime <runtime>] <container-name> [<command> [<arg>...]]")
+		flags.PrintDefaults()
+	}
+
+	parseFlags(flags, args)
+
+	if flags.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "Errors:")
+		fmt.Fprintln(os.Stderr, " a container name is required")
+		fmt.Fprintln(os.Stderr)
+		flags.Usage()
+		os.Exit(exitParseError)
+	}
+
+	if len(runtimeName) == 0 {
+		runtimeName = piper.DetectRuntime()
+	}
+
+	runtime, err := piper.NewRuntime(runtimeName, os.Stdin, os.Stdout, os.Stderr)
+	if err != nil {
+		fail(exitRuntimeError, err)
+	}
+
+	interceptor, ok := runtime.(piper.Interceptor)
+	if !ok {
+		fail(exitRuntimeError, fmt.Errorf("cannot intercept containers run with %s", runtimeName))
+	}
+
+	err = interceptor.Intercept(flags.Arg(0), flags.Args()[1:])
+	if exitError, ok := err.(piper.ContainerExitError); ok {
+		os.Exit(exitError.Status)
+	}
+	if err != nil {
+		fail(exitRuntimeError, err)
+	}
+}
@@ -0,0 +1,84 @@
+package main_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+
+	"github.com/onsi/gomega/gexec"
+	"github.com/ryanmoran/piper/fakes/docker/dockerconfig"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("piper intercept", func() {
+	BeforeEach(func() {
+		err := os.RemoveAll(dockerconfig.InvocationsPath)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("opens a shell in a running task container", func() {
+		command := exec.Command(pathToPiper, "intercept", "piper-some-name")
+		command.Env = append(os.Environ(), fmt.Sprintf("%s=true", dockerconfig.ContainerRunningEnv))
+
+		session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+		Expect(err).NotTo(HaveOccurred())
+
+		Eventually(session).Should(gexec.Exit(0))
+
+		dockerInvocations, err := ioutil.ReadFile(dockerconfig.InvocationsPath)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(splitInvocations(dockerInvocations)).To(Equal([]string{
+			fmt.Sprintf("%s inspect --format={{.State.Running}} piper-some-name", pathToDocker),
+			fmt.Sprintf("%s exec --interactive --tty piper-some-name sh", pathToDocker),
+		}))
+	})
+
+	It("opens the given command in a snapshot of a kept task container", func() {
+		command := exec.Command(pathToPiper, "intercept", "-runtime", "docker", "piper-some-name", "bash", "-l")
+
+		session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+		Expect(err).NotTo(HaveOccurred())
+
+		Eventually(session).Should(gexec.Exit(0))
+
+		dockerInvocations, err := ioutil.ReadFile(dockerconfig.InvocationsPath)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(splitInvocations(dockerInvocations)).To(Equal([]string{
+			fmt.Sprintf("%s inspect --format={{.State.Running}} piper-some-name", pathToDocker),
+			fmt.Sprintf("%s commit piper-some-name piper-snapshot:piper-some-name", pathToDocker),
+			fmt.Sprintf("%s run --rm --interactive --tty --volumes-from=piper-some-name piper-snapshot:piper-some-name bash -l", pathToDocker),
+			fmt.Sprintf("%s rmi piper-snapshot:piper-some-name", pathToDocker),
+		}))
+	})
+
+	It("exits with the status of the shell", func() {
+		command := exec.Command(pathToPiper, "intercept", "piper-some-name")
+		command.Env = append(os.Environ(), fmt.Sprintf("%s=5", dockerconfig.RunExitStatusEnv))
+
+		session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+		Expect(err).NotTo(HaveOccurred())
+
+		Eventually(session).Should(gexec.Exit(5))
+		Expect(session.Err.Contents()).To(BeEmpty())
+	})
+
+	Context("failure cases", func() {
+		Context("when no container name is given", func() {
+			It("prints an error and exits with the parse error status", func() {
+				command := exec.Command(pathToPiper, "intercept")
+
+				session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+				Expect(err).NotTo(HaveOccurred())
+
+				Eventually(session).Should(gexec.Exit(121))
+				Expect(session.Err.Contents()).To(ContainSubstring("a container name is required"))
+				Expect(session.Err.Contents()).To(ContainSubstring("Usage: piper intercept"))
+			})
+		})
+	})
+})
@@ -1,43 +1,158 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"log"
 	"os"
-	"os/exec"
+	"os/signal"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/ryanmoran/piper"
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "intercept" {
+		intercept(os.Args[2:])
+		return
+	}
+
 	var (
-		taskFilePath string
-		inputPairs   ResourcePairs
-		outputPairs  ResourcePairs
-		privileged   bool
-		dryRun       bool
-		rm           bool
-		repository   string
-		tag          string
+		taskFilePath    string
+		inputPairs      ResourcePairs
+		outputPairs     ResourcePairs
+		inputMaps       ResourcePairs
+		outputMaps      ResourcePairs
+		privileged      bool
+		dryRun          bool
+		useEnvFile      bool
+		rm              bool
+		repository      string
+		tag             string
+		user            string
+		hostUser        bool
+		cpuLimit        uint64
+		memoryLimit     string
+		validate        bool
+		pipelinePath    string
+		jobName         string
+		taskName        string
+		varPairs        ResourcePairs
+		yamlVarPairs    ResourcePairs
+		varFiles        ResourcePairs
+		varsDir         string
+		envVarPrefix    string
+		encVarsFile     string
+		redact          bool
+		redactRules     ResourcePairs
+		configPath      string
+		envPairs        ResourcePairs
+		envFiles        ResourcePairs
+		passEnv         ResourcePairs
+		unsetEnv        ResourcePairs
+		printEnv        bool
+		strictParams    bool
+		dockerAPI       bool
+		runtimeName     string
+		stopGracePeriod time.Duration
+		containerName   string
+		keepOnFailure   bool
+		interactive     bool
+		noTty           bool
+		pullPolicyName  string
+		offline         bool
+		pullRetries     int
+		pullBackoff     time.Duration
 	)
 
-	flag.StringVar(&taskFilePath, "c", "", "path to the task configuration file")
+	flag.CommandLine.Init(os.Args[0], flag.ContinueOnError)
+
+	flag.StringVar(&taskFilePath, "c", "", "path to the task configuration file, or - to read it from stdin")
+	flag.StringVar(&pipelinePath, "pipeline", "", "path to a pipeline configuration file to run a task from")
+	flag.StringVar(&jobName, "job", "", "name of the pipeline job containing the task")
+	flag.StringVar(&taskName, "task", "", "name of the task step in the pipeline job")
 	flag.Var(&inputPairs, "i", "<input-name>=<input-location>")
 	flag.Var(&outputPairs, "o", "<output-name>=<output-location>")
+	flag.Var(&varPairs, "v", "<var-name>=<value> to interpolate into ((var-name))")
+	flag.Var(&yamlVarPairs, "y", "<var-name>=<yaml-value> to interpolate into ((var-name))")
+	flag.Var(&varFiles, "l", "path to a YAML file of vars to interpolate")
+	flag.StringVar(&varsDir, "vars-dir", "", "path to a directory with one file per secret ((var)), like a mounted Kubernetes secret")
+	flag.StringVar(&envVarPrefix, "env-vars-prefix", "", "resolve secret ((vars)) from environment variables with this prefix")
+	flag.StringVar(&encVarsFile, "encrypted-vars", "", "path to a vars file encrypted with `openssl enc -aes-256-cbc -pbkdf2`, using the passphrase in $PIPER_VARS_PASSPHRASE")
+	flag.BoolVar(&redact, "redact", false, fmt.Sprintf("masks env values in printed output for keys matching %s", strings.Join(piper.DefaultRedactPatterns, ", ")))
+	flag.Var(&redactRules, "redact-pattern", "masks env values in printed output for keys matching this glob pattern")
+	flag.StringVar(&configPath, "piper-config", "", fmt.Sprintf("path to a piper configuration file (default %s if it exists)", piper.DefaultPiperConfigPath))
+	flag.Var(&envPairs, "e", "<env-name>=<value> to set in the task container, overriding params, the host environment and -env-file")
+	flag.Var(&envFiles, "env-file", "path to a dotenv file of env vars to set in the task container, overriding params and the host environment")
+	flag.Var(&passEnv, "pass-env", "glob pattern of host env vars to forward to the task container even when they are not params")
+	flag.Var(&unsetEnv, "unset-env", "name of an env var to leave unset in the task container, even if it is a param")
+	flag.BoolVar(&strictParams, "strict-params", false, "fails before pulling the image when any param is left empty")
+	flag.BoolVar(&printEnv, "print-env", false, "prints the env of the task container and where each value came from")
+	flag.Var(&inputMaps, "input-mapping", "<task-input-name>=<input-name>")
+	flag.Var(&outputMaps, "output-mapping", "<task-output-name>=<output-name>")
 	flag.BoolVar(&privileged, "p", false, "run the task with full privileges")
 	flag.BoolVar(&dryRun, "dry-run", false, "prints the docker commands without running them")
+	flag.BoolVar(&interactive, "interactive", false, "passes stdin through to the task")
+	flag.BoolVar(&noTty, "no-tty", false, "does not allocate a tty for the task, even when piper runs in a terminal")
+	flag.BoolVar(&useEnvFile, "use-env-file", false, "passes env vars to docker in a private temporary --env-file instead of --env arguments")
+	flag.StringVar(&runtimeName, "runtime", "", fmt.Sprintf("container runtime CLI to run the task with, one of %s (default the first found on the $PATH)", strings.Join(piper.Runtimes, ", ")))
+	flag.BoolVar(&dockerAPI, "docker-api", false, "talks to the Docker Engine API at $DOCKER_HOST instead of running the docker CLI")
+	flag.DurationVar(&stopGracePeriod, "stop-grace-period", 10*time.Second, "how long the task container is given to stop on Ctrl-C or SIGTERM before it is killed")
+	flag.StringVar(&containerName, "name", "", "name of the task container, to find it with `piper intercept` (default piper-<random>)")
+	flag.BoolVar(&keepOnFailure, "keep-on-failure", false, "keeps the task container when the task fails, even with -rm, so that it can be opened with `piper intercept`")
+	flag.StringVar(&pullPolicyName, "pull", string(piper.PullAlways), "when to pull the task image: always, missing to pull it only when it is not present locally, or never")
+	flag.IntVar(&pullRetries, "pull-retries", 3, "how many times to retry pulling the task image after a network, rate limit or registry error")
+	flag.DurationVar(&pullBackoff, "pull-backoff", time.Second, "how long to wait before the first retry of a pull, doubling for each retry up to 30s")
+	flag.BoolVar(&offline, "offline", false, "never pulls the task image and fails before running the task when it is not present locally")
+	flag.BoolVar(&validate, "validate", false, "validates the task configuration file without running it")
 	flag.BoolVar(&rm, "rm", false, "removes the docker container after test")
 	flag.StringVar(&repository, "r", "", "docker image repo")
 	flag.StringVar(&tag, "t", "", "image tag")
+	flag.StringVar(&user, "user", "", "user to run the task as, overrides run.user")
+	flag.BoolVar(&hostUser, "host-user", false, "run the task as the current host user so outputs stay writable")
+	flag.Uint64Var(&cpuLimit, "cpu", 0, "cpu shares for the task container, overrides container_limits.cpu")
+	flag.StringVar(&memoryLimit, "memory", "", "memory limit for the task container (e.g. 512MB), overrides container_limits.memory")
 
-	flag.Parse()
+	parseFlags(flag.CommandLine, os.Args[1:])
 
 	var errors []string
-	if len(taskFilePath) == 0 {
+	if len(pipelinePath) > 0 {
+		if len(taskFilePath) > 0 {
+			errors = append(errors, fmt.Sprintf(" -c and -pipeline cannot be used together"))
+		}
+		if len(jobName) == 0 || len(taskName) == 0 {
+			errors = append(errors, fmt.Sprintf(" -job and -task are required with -pipeline"))
+		}
+	} else if len(taskFilePath) == 0 {
 		errors = append(errors, fmt.Sprintf(" -c is a required flag"))
 	}
 
+	if len(user) > 0 && hostUser {
+		errors = append(errors, fmt.Sprintf(" -user and -host-user cannot be used together"))
+	}
+
+	if len(runtimeName) > 0 && dockerAPI {
+		errors = append(errors, fmt.Sprintf(" -runtime and -docker-api cannot be used together"))
+	}
+
+	pullPolicy, err := piper.ParsePullPolicy(pullPolicyName)
+	if err != nil {
+		errors = append(errors, fmt.Sprintf(" %s", err))
+	}
+
+	if offline {
+		if pullPolicy == piper.PullAlways && isFlagSet("pull") {
+			errors = append(errors, fmt.Sprintf(" -offline and -pull=always cannot be used together"))
+		}
+		pullPolicy = piper.PullNever
+	}
+
 	if len(errors) > 0 {
 		fmt.Fprintln(os.Stderr, "Errors:")
 		for _, err := range errors {
@@ -45,12 +160,126 @@ func main() {
 		}
 		fmt.Fprintln(os.Stderr, "\nUsage:")
 		flag.PrintDefaults()
-		os.Exit(1)
+		os.Exit(exitParseError)
+	}
+
+	variables, err := piper.VariablesBuilder{}.Build(varPairs, yamlVarPairs, varFiles)
+	if err != nil {
+		fail(exitParseError, err)
+	}
+
+	var credentials piper.MultiVariables
+	if len(varsDir) > 0 {
+		credentials = append(credentials, piper.DirectoryVariables{Path: varsDir})
+	}
+	if len(envVarPrefix) > 0 {
+		credentials = append(credentials, piper.EnvironmentVariables{Prefix: envVarPrefix, Environment: os.Environ()})
+	}
+	if len(encVarsFile) > 0 {
+		encryptedVariables, err := piper.LoadEncryptedFileVariables(encVarsFile, os.Getenv("PIPER_VARS_PASSPHRASE"))
+		if err != nil {
+			fail(exitParseError, err)
+		}
+		credentials = append(credentials, encryptedVariables)
+	}
+
+	var (
+		taskConfig   piper.Task
+		pipelineTask piper.PipelineTask
+	)
+	parser := piper.Parser{Variables: variables, Credentials: credentials, ImageGiven: len(repository) > 0}
+	taskSource := taskFilePath
+	switch {
+	case len(pipelinePath) > 0:
+		pipelineTask, err = piper.PipelineParser{Variables: variables, Credentials: credentials}.Parse(pipelinePath, jobName, taskName)
+		if err != nil {
+			fail(exitParseError, err)
+		}
+
+		if pipelineTask.Config != nil {
+			taskSource = pipelinePath
+			taskConfig, err = parser.ParseNode(pipelineTask.Config)
+		} else {
+			taskSource, err = pipelineTask.TaskFilePath(inputPairs)
+			if err != nil {
+				fail(exitParseError, err)
+			}
+			taskConfig, err = parser.Parse(taskSource)
+		}
+	case taskFilePath == "-":
+		taskSource = "stdin"
+
+		var contents []byte
+		contents, err = ioutil.ReadAll(os.Stdin)
+		if err != nil {
+			fail(exitParseError, err)
+		}
+		taskConfig, err = parser.ParseContents(contents)
+	default:
+		taskConfig, err = parser.Parse(taskFilePath)
 	}
 
-	taskConfig, err := piper.Parser{}.Parse(taskFilePath)
+	if validate {
+		if validationErrors, ok := err.(piper.ValidationErrors); ok {
+			for _, validationError := range validationErrors {
+				fmt.Fprintf(os.Stderr, "%s: %s\n", taskSource, validationError)
+			}
+			os.Exit(exitParseError)
+		}
+		if err != nil {
+			fail(exitParseError, err)
+		}
+
+		fmt.Fprintf(os.Stdout, "%s: task config is valid\n", taskSource)
+		os.Exit(0)
+	}
 	if err != nil {
-		log.Fatalln(err)
+		fail(exitParseError, err)
+	}
+
+	if len(pipelineTask.Params) > 0 && taskConfig.Params == nil {
+		taskConfig.Params = make(map[string]string)
+	}
+	for key, value := range pipelineTask.Params {
+		taskConfig.Params[key] = value
+	}
+	privileged = privileged || pipelineTask.Privileged
+	taskConfig.Variables = append(taskConfig.Variables, pipelineTask.Variables...)
+
+	var secrets []string
+	for _, variable := range taskConfig.Variables {
+		if variable.Secret {
+			secrets = append(secrets, variable.Value)
+		}
+	}
+
+	var stdout io.Writer = os.Stdout
+	if dryRun {
+		stdout = piper.RedactingWriter{Writer: os.Stdout, Secrets: secrets}
+
+		for _, variable := range taskConfig.Variables {
+			value := variable.Value
+			if variable.Secret {
+				value = piper.Redacted
+			}
+			fmt.Fprintf(stdout, "# ((%s)) = %s\n", variable.Name, value)
+		}
+	}
+
+	workdir, err := taskConfig.Run.WorkingDirectory()
+	if err != nil {
+		fail(exitParseError, err)
+	}
+
+	limits := taskConfig.ContainerLimits
+	if cpuLimit > 0 {
+		limits.CPU = cpuLimit
+	}
+	if len(memoryLimit) > 0 {
+		limits.Memory, err = piper.ParseMemoryLimit(memoryLimit)
+		if err != nil {
+			fail(exitParseError, err)
+		}
 	}
 
 	var resources []piper.VolumeMount
@@ -58,22 +287,82 @@ func main() {
 	resources = append(resources, taskConfig.Outputs...)
 	resources = append(resources, taskConfig.Caches...)
 
-	volumeMounts, err := piper.VolumeMountBuilder{}.Build(resources, inputPairs, outputPairs)
+	volumeMountBuilder := piper.VolumeMountBuilder{
+		InputMapping:  mergeMappings(inputMaps, pipelineTask.InputMapping),
+		OutputMapping: mergeMappings(outputMaps, pipelineTask.OutputMapping),
+	}
+
+	volumeMounts, err := volumeMountBuilder.Build(resources, inputPairs, outputPairs)
 	if err != nil {
-		log.Fatalln(err)
+		fail(exitMountError, err)
 	}
 
-	envVars := piper.EnvVarBuilder{}.Build(os.Environ(), taskConfig.Params)
+	envVarBuilder := piper.EnvVarBuilder{
+		PassEnv:   passEnv,
+		EnvFiles:  envFiles,
+		Overrides: envPairs,
+		Unset:     unsetEnv,
+	}
 
-	dockerPath, err := exec.LookPath("docker")
+	envVars, err := envVarBuilder.Build(os.Environ(), taskConfig.Params)
 	if err != nil {
-		log.Fatalln(err)
+		fail(exitParseError, err)
+	}
+
+	if emptyParams := piper.EmptyParams(envVars, taskConfig.Params); len(emptyParams) > 0 {
+		if strictParams {
+			fail(exitParseError, fmt.Errorf("The following params are required but not set: %s.", strings.Join(emptyParams, ", ")))
+		}
+
+		fmt.Fprintf(os.Stderr, "warning: the following params are empty: %s\n", strings.Join(emptyParams, ", "))
+	}
+
+	if len(configPath) == 0 {
+		if _, err := os.Stat(piper.DefaultPiperConfigPath); err == nil {
+			configPath = piper.DefaultPiperConfigPath
+		}
+	}
+
+	var piperConfig piper.PiperConfig
+	if len(configPath) > 0 {
+		piperConfig, err = piper.PiperConfigParser{}.Parse(configPath)
+		if err != nil {
+			fail(exitParseError, err)
+		}
+	}
+
+	redactor := piper.EnvRedactor{Values: secrets}
+	if redact {
+		redactor.Patterns = append(redactor.Patterns, piper.DefaultRedactPatterns...)
+	}
+	redactor.Patterns = append(redactor.Patterns, redactRules...)
+	if piperConfig.Redact != nil {
+		redactor.Patterns = append(redactor.Patterns, piperConfig.Redact.Patterns...)
+		redactor.Keys = piperConfig.Redact.Secrets
+	}
+	envVars = redactor.Redact(envVars)
+
+	if printEnv {
+		for _, envVar := range envVars {
+			value := envVar.Value
+			if envVar.Secret {
+				value = piper.Redacted
+			}
+			fmt.Fprintf(stdout, "# env %s=%s (%s)\n", envVar.Key, value, envVar.Source)
+		}
 	}
 
-	dockerClient := piper.DockerClient{
-		Command: exec.Command(dockerPath),
-		Stdout:  os.Stdout,
-		Stderr:  os.Stderr,
+	var docker piper.Runtime
+	if dockerAPI {
+		docker, err = piper.NewDockerEngineClient(os.Getenv("DOCKER_HOST"), os.Stdin, stdout, os.Stderr)
+	} else {
+		if len(runtimeName) == 0 {
+			runtimeName = piper.DetectRuntime()
+		}
+		docker, err = piper.NewRuntime(runtimeName, os.Stdin, stdout, os.Stderr)
+	}
+	if err != nil {
+		fail(exitRuntimeError, err)
 	}
 
 	dockerRepo := taskConfig.Image
@@ -81,29 +370,188 @@ func main() {
 		dockerRepo = repository
 	}
 	if len(tag) > 0 {
-		dockerRepo += fmt.Sprintf(":%s", tag)
+		dockerRepo = piper.WithTag(dockerRepo, tag)
 	}
 
-	err = dockerClient.Pull(dockerRepo, dryRun)
+	pull, err := pullPolicy.ShouldPull(docker, dockerRepo)
 	if err != nil {
-		log.Fatalln(err)
+		if offline {
+			err = fmt.Errorf("cannot run offline: %s", err)
+		}
+		fail(exitPullError, err)
 	}
 
-	dockerClient = piper.DockerClient{
-		Command: exec.Command(dockerPath),
-		Stdout:  os.Stdout,
-		Stderr:  os.Stderr,
+	if pull {
+		puller := piper.ImagePuller{
+			Runtime:    docker,
+			Retries:    pullRetries,
+			Backoff:    pullBackoff,
+			MaxBackoff: maxPullBackoff,
+			Stderr:     os.Stderr,
+		}
+
+		// The image_resource credentials are only kept for the pull, and not
+		// in the user's docker config.
+		var registryConfigDir string
+		if auth, ok := taskConfig.ImageResource.Auth(); ok && len(repository) == 0 {
+			if !dryRun {
+				registryConfigDir, err = ioutil.TempDir("", "piper-registry-")
+				if err != nil {
+					fail(exitPullError, err)
+				}
+				puller.Runtime = piper.WithRegistryConfigDir(docker, registryConfigDir)
+			}
+
+			err = puller.Runtime.Login(auth, dryRun)
+		}
+
+		if err == nil {
+			err = puller.Pull(dockerRepo, dryRun)
+		}
+		if registryConfigDir != "" {
+			os.RemoveAll(registryConfigDir)
+		}
+		if err != nil {
+			fail(exitPullError, err)
+		}
+	} else if dryRun {
+		fmt.Fprintf(stdout, "# not pulling %s: it is present locally (-pull=%s)\n", dockerRepo, pullPolicy)
 	}
 
 	command := []string{taskConfig.Run.Path}
 	command = append(command, taskConfig.Run.Args...)
 
-	err = dockerClient.Run(command, dockerRepo, envVars, volumeMounts, privileged, dryRun, rm)
+	runUser := taskConfig.Run.User
+	if len(user) > 0 {
+		runUser = user
+	}
+
+	// The generated name is left out of the dry-run output, so that it is the
+	// same on every run.
+	if len(containerName) == 0 && !dryRun {
+		containerName, err = piper.NewContainerName()
+		if err != nil {
+			fail(exitRuntimeError, err)
+		}
+	}
+
+	runOptions := piper.DockerRunOptions{
+		Workdir:    workdir,
+		User:       runUser,
+		Limits:     limits,
+		Privileged: privileged,
+		Rm:         rm,
+		EnvFile:    useEnvFile,
+		HostUser:   hostUser,
+
+		Name:            containerName,
+		StopGracePeriod: stopGracePeriod,
+		KeepOnFailure:   keepOnFailure,
+
+		Tty:         !noTty && piper.IsTerminal(os.Stdout) && (!interactive || piper.IsTerminal(os.Stdin)),
+		Interactive: interactive,
+	}
+
+	ctx, cancel := context.WithCancelCause(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		received := <-signals
+		// A second signal kills piper without waiting for the container.
+		signal.Stop(signals)
+		cancel(interruptedError{Signal: received.(syscall.Signal)})
+	}()
+
+	err = docker.RunContext(ctx, command, dockerRepo, envVars, volumeMounts, runOptions, dryRun)
+	if interrupted, ok := context.Cause(ctx).(interruptedError); ok {
+		log.Println(interrupted)
+		os.Exit(128 + int(interrupted.Signal))
+	}
 	if err != nil {
-		log.Fatalln(err)
+		log.Println(err)
+
+		exitError, ok := err.(piper.ContainerExitError)
+		if !ok {
+			os.Exit(exitRuntimeError)
+		}
+
+		// Only a task that failed in its container leaves a container to keep.
+		if keepOnFailure {
+			log.Printf("kept container %s, open a shell in it with: piper intercept %s", containerName, containerName)
+		}
+		os.Exit(exitError.Status)
 	}
 }
 
+// Exit statuses reserved for failures of piper itself, so that they can be
+// told apart from the exit status of the task.
+const (
+	exitParseError   = 121
+	exitMountError   = 122
+	exitPullError    = 123
+	exitRuntimeError = 124
+)
+
+const maxPullBackoff = 30 * time.Second
+
+// interruptedError cancels the task when piper receives a signal. piper then
+// exits with 128 plus the signal number, like a shell does.
+type interruptedError struct {
+	Signal syscall.Signal
+}
+
+func (e interruptedError) Error() string {
+	return fmt.Sprintf("stopped the task after receiving %s", e.Signal)
+}
+
+// isFlagSet reports whether the flag was given on the command line.
+func isFlagSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+
+	return set
+}
+
+// parseFlags parses the flags, exiting with the parse error status when they
+// cannot be parsed. The flag package has already printed the error and usage.
+func parseFlags(flags *flag.FlagSet, args []string) {
+	err := flags.Parse(args)
+	if err == flag.ErrHelp {
+		os.Exit(0)
+	}
+	if err != nil {
+		os.Exit(exitParseError)
+	}
+}
+
+func fail(status int, err error) {
+	log.Println(err)
+	os.Exit(status)
+}
+
+// mergeMappings adds the mappings of a pipeline task step to those given on
+// the command line, which take precedence.
+func mergeMappings(pairs ResourcePairs, stepMapping map[string]string) []string {
+	mapped := make(map[string]bool)
+	for _, pair := range pairs {
+		mapped[strings.SplitN(pair, "=", 2)[0]] = true
+	}
+
+	var stepPairs []string
+	for name, alias := range stepMapping {
+		if !mapped[name] {
+			stepPairs = append(stepPairs, fmt.Sprintf("%s=%s", name, alias))
+		}
+	}
+	sort.Strings(stepPairs)
+
+	return append(append([]string{}, pairs...), stepPairs...)
+}
+
 type ResourcePairs []string
 
 func (p *ResourcePairs) Set(resource string) error {
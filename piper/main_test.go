@@ -6,6 +6,7 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"regexp"
 	"strings"
 
 	"github.com/onsi/gomega/gexec"
@@ -23,6 +24,7 @@ var _ = Describe("Piper", func() {
 
 	It("runs a concourse task", func() {
 		command := exec.Command(pathToPiper,
+			"-name", "piper-some-name",
 			"-c", "fixtures/task.yml",
 			"-i", "input-1=/tmp/local-1",
 			"-o", "output-1=/tmp/local-2",
@@ -37,15 +39,16 @@ var _ = Describe("Piper", func() {
 		dockerInvocations, err := ioutil.ReadFile(dockerconfig.InvocationsPath)
 		Expect(err).NotTo(HaveOccurred())
 
-		dockerCommands := strings.Split(strings.TrimSpace(string(dockerInvocations)), "\n")
+		dockerCommands := splitInvocations(dockerInvocations)
 		Expect(dockerCommands).To(Equal([]string{
 			fmt.Sprintf("%s pull my-image", pathToDocker),
-			fmt.Sprintf("%s run --workdir=/tmp/build --env=VAR1=var-1 --volume=/tmp/local-1:/tmp/build/input-1 --volume=/tmp/local-2:/tmp/build/output-1 --tty my-image my-task.sh", pathToDocker),
+			fmt.Sprintf("%s run --workdir=/tmp/build --name=piper-some-name --env=VAR1=var-1 --volume=/tmp/local-1:/tmp/build/input-1 --volume=/tmp/local-2:/tmp/build/output-1 my-image my-task.sh", pathToDocker),
 		}))
 	})
 
 	It("runs a concourse task with input image and tag", func() {
 		command := exec.Command(pathToPiper,
+			"-name", "piper-some-name",
 			"-c", "fixtures/task.yml",
 			"-r", "my-image",
 			"-t", "my-tag",
@@ -62,19 +65,697 @@ var _ = Describe("Piper", func() {
 		dockerInvocations, err := ioutil.ReadFile(dockerconfig.InvocationsPath)
 		Expect(err).NotTo(HaveOccurred())
 
-		dockerCommands := strings.Split(strings.TrimSpace(string(dockerInvocations)), "\n")
+		dockerCommands := splitInvocations(dockerInvocations)
 		Expect(dockerCommands).To(Equal([]string{
 			fmt.Sprintf("%s pull my-image:my-tag", pathToDocker),
-			fmt.Sprintf("%s run --workdir=/tmp/build --env=VAR1=var-1 --volume=/tmp/local-1:/tmp/build/input-1 --volume=/tmp/local-2:/tmp/build/output-1 --tty my-image:my-tag my-task.sh", pathToDocker),
+			fmt.Sprintf("%s run --workdir=/tmp/build --name=piper-some-name --env=VAR1=var-1 --volume=/tmp/local-1:/tmp/build/input-1 --volume=/tmp/local-2:/tmp/build/output-1 my-image:my-tag my-task.sh", pathToDocker),
+		}))
+	})
+
+	It("runs a task without an image with the image given with -r", func() {
+		command := exec.Command(pathToPiper,
+			"-dry-run",
+			"-c", "fixtures/task_without_image.yml",
+			"-r", "busybox")
+		session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+		Expect(err).NotTo(HaveOccurred())
+
+		Eventually(session).Should(gexec.Exit(0))
+
+		dockerCommands := splitInvocations(session.Out.Contents())
+		Expect(dockerCommands).To(Equal([]string{
+			fmt.Sprintf("%s pull busybox", pathToDocker),
+			fmt.Sprintf("%s run --workdir=/tmp/build busybox my-task.sh", pathToDocker),
+		}))
+	})
+
+	It("replaces the tag of the image_resource with -t", func() {
+		command := exec.Command(pathToPiper,
+			"-dry-run",
+			"-c", "fixtures/advanced_task.yml",
+			"-i", "input=/tmp/local-1",
+			"-o", "output=/tmp/local-2",
+			"-t", "my-tag")
+		session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+		Expect(err).NotTo(HaveOccurred())
+
+		Eventually(session).Should(gexec.Exit(0))
+
+		dockerCommands := splitInvocations(session.Out.Contents())
+		Expect(dockerCommands[0]).To(Equal(fmt.Sprintf("%s pull my-image:my-tag", pathToDocker)))
+		Expect(dockerCommands[1]).To(HaveSuffix(" my-image:my-tag my-task.sh"))
+	})
+
+	It("replaces the version digest of the image_resource with -t", func() {
+		command := exec.Command(pathToPiper,
+			"-dry-run",
+			"-c", "fixtures/authenticated_task.yml",
+			"-t", "my-tag")
+		session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+		Expect(err).NotTo(HaveOccurred())
+
+		Eventually(session).Should(gexec.Exit(0))
+
+		dockerCommands := splitInvocations(session.Out.Contents())
+		Expect(dockerCommands).To(ContainElement(fmt.Sprintf("%s pull registry.example.com/my-image:my-tag", pathToDocker)))
+		Expect(dockerCommands[len(dockerCommands)-1]).To(HaveSuffix(" registry.example.com/my-image:my-tag my-task.sh"))
+	})
+
+	It("runs a concourse task in the given run.dir", func() {
+		command := exec.Command(pathToPiper,
+			"-name", "piper-some-name",
+			"-c", "fixtures/task_with_dir.yml",
+			"-i", "input-1=/tmp/local-1",
+		)
+
+		session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+		Expect(err).NotTo(HaveOccurred())
+
+		Eventually(session).Should(gexec.Exit(0))
+
+		dockerInvocations, err := ioutil.ReadFile(dockerconfig.InvocationsPath)
+		Expect(err).NotTo(HaveOccurred())
+
+		dockerCommands := splitInvocations(dockerInvocations)
+		Expect(dockerCommands).To(Equal([]string{
+			fmt.Sprintf("%s pull my-image", pathToDocker),
+			fmt.Sprintf("%s run --workdir=/tmp/build/input-1 --name=piper-some-name --user=task-user --volume=/tmp/local-1:/tmp/build/input-1 my-image my-task.sh", pathToDocker),
+		}))
+	})
+
+	It("runs a concourse task as the user given on the command line", func() {
+		command := exec.Command(pathToPiper,
+			"-c", "fixtures/task_with_dir.yml",
+			"-i", "input-1=/tmp/local-1",
+			"-user", "some-user",
+		)
+
+		session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+		Expect(err).NotTo(HaveOccurred())
+
+		Eventually(session).Should(gexec.Exit(0))
+
+		dockerInvocations, err := ioutil.ReadFile(dockerconfig.InvocationsPath)
+		Expect(err).NotTo(HaveOccurred())
+
+		dockerCommands := splitInvocations(dockerInvocations)
+		Expect(dockerCommands[1]).To(ContainSubstring("--user=some-user "))
+	})
+
+	It("runs a concourse task as the host user", func() {
+		command := exec.Command(pathToPiper,
+			"-c", "fixtures/task_with_dir.yml",
+			"-i", "input-1=/tmp/local-1",
+			"-host-user",
+		)
+
+		session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+		Expect(err).NotTo(HaveOccurred())
+
+		Eventually(session).Should(gexec.Exit(0))
+
+		dockerInvocations, err := ioutil.ReadFile(dockerconfig.InvocationsPath)
+		Expect(err).NotTo(HaveOccurred())
+
+		dockerCommands := splitInvocations(dockerInvocations)
+		Expect(dockerCommands[1]).To(ContainSubstring(fmt.Sprintf("--user=%d:%d ", os.Getuid(), os.Getgid())))
+	})
+
+	Context("when the task is run with another container runtime", func() {
+		var runtimeDir, path string
+
+		BeforeEach(func() {
+			var err error
+			runtimeDir, err = ioutil.TempDir("", "")
+			Expect(err).NotTo(HaveOccurred())
+
+			for _, name := range []string{"podman", "nerdctl"} {
+				Expect(os.Symlink(pathToDocker, filepath.Join(runtimeDir, name))).To(Succeed())
+			}
+
+			path = os.Getenv("PATH")
+		})
+
+		AfterEach(func() {
+			os.Setenv("PATH", path)
+			Expect(os.RemoveAll(runtimeDir)).To(Succeed())
+		})
+
+		It("runs the task with the runtime given with -runtime", func() {
+			os.Setenv("PATH", fmt.Sprintf("%s:%s", runtimeDir, path))
+
+			command := exec.Command(pathToPiper,
+				"-name", "piper-some-name",
+				"-c", "fixtures/task.yml",
+				"-i", "input-1=/tmp/local-1",
+				"-o", "output-1=/tmp/local-2",
+				"-runtime", "nerdctl",
+			)
+
+			session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+			Expect(err).NotTo(HaveOccurred())
+
+			Eventually(session).Should(gexec.Exit(0))
+
+			dockerInvocations, err := ioutil.ReadFile(dockerconfig.InvocationsPath)
+			Expect(err).NotTo(HaveOccurred())
+
+			dockerCommands := splitInvocations(dockerInvocations)
+			Expect(dockerCommands).To(Equal([]string{
+				fmt.Sprintf("%s/nerdctl pull my-image", runtimeDir),
+				fmt.Sprintf("%s/nerdctl run --workdir=/tmp/build --name=piper-some-name --env=VAR1=default-var-1 --volume=/tmp/local-1:/tmp/build/input-1 --volume=/tmp/local-2:/tmp/build/output-1 my-image my-task.sh", runtimeDir),
+			}))
+		})
+
+		It("detects podman when docker is not on the $PATH and keeps the host user", func() {
+			os.Setenv("PATH", runtimeDir)
+
+			command := exec.Command(pathToPiper,
+				"-name", "piper-some-name",
+				"-c", "fixtures/task_with_dir.yml",
+				"-i", "input-1=/tmp/local-1",
+				"-host-user",
+			)
+
+			session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+			Expect(err).NotTo(HaveOccurred())
+
+			Eventually(session).Should(gexec.Exit(0))
+
+			dockerInvocations, err := ioutil.ReadFile(dockerconfig.InvocationsPath)
+			Expect(err).NotTo(HaveOccurred())
+
+			dockerCommands := splitInvocations(dockerInvocations)
+			Expect(dockerCommands).To(HaveLen(2))
+			Expect(dockerCommands[0]).To(Equal(fmt.Sprintf("%s/podman pull docker.io/library/my-image", runtimeDir)))
+			Expect(dockerCommands[1]).To(HavePrefix(fmt.Sprintf("%s/podman run --workdir=/tmp/build/input-1 --name=piper-some-name --userns=keep-id --user=%d:%d ", runtimeDir, os.Getuid(), os.Getgid())))
+			Expect(dockerCommands[1]).To(HaveSuffix(" docker.io/library/my-image my-task.sh"))
+		})
+	})
+
+	It("passes stdin through to the task with -interactive", func() {
+		command := exec.Command(pathToPiper,
+			"-name", "piper-some-name",
+			"-c", "fixtures/task.yml",
+			"-i", "input-1=/tmp/local-1",
+			"-o", "output-1=/tmp/local-2",
+			"-interactive",
+		)
+		command.Env = append(os.Environ(), "VAR1=var-1")
+
+		session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+		Expect(err).NotTo(HaveOccurred())
+
+		Eventually(session).Should(gexec.Exit(0))
+
+		dockerInvocations, err := ioutil.ReadFile(dockerconfig.InvocationsPath)
+		Expect(err).NotTo(HaveOccurred())
+
+		dockerCommands := splitInvocations(dockerInvocations)
+		Expect(dockerCommands).To(Equal([]string{
+			fmt.Sprintf("%s pull my-image", pathToDocker),
+			fmt.Sprintf("%s run --workdir=/tmp/build --name=piper-some-name --env=VAR1=var-1 --volume=/tmp/local-1:/tmp/build/input-1 --volume=/tmp/local-2:/tmp/build/output-1 --interactive my-image my-task.sh", pathToDocker),
 		}))
 	})
 
 	It("runs a concourse task with complex inputs", func() {
 		command := exec.Command(pathToPiper,
-			"-c", "fixtures/advanced_task.yml",
-			"-i", "input=/tmp/local-1",
-			"-o", "output=/tmp/local-2",
-			"-p")
+			"-name", "piper-some-name",
+			"-c", "fixtures/advanced_task.yml",
+			"-i", "input=/tmp/local-1",
+			"-o", "output=/tmp/local-2",
+			"-p")
+		session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+		Expect(err).NotTo(HaveOccurred())
+
+		Eventually(session).Should(gexec.Exit(0))
+
+		dockerInvocations, err := ioutil.ReadFile(dockerconfig.InvocationsPath)
+		Expect(err).NotTo(HaveOccurred())
+
+		dockerCommands := splitInvocations(dockerInvocations)
+		Expect(dockerCommands).To(Equal([]string{
+			fmt.Sprintf("%s pull my-image:x.y", pathToDocker),
+			fmt.Sprintf("%s run --workdir=/tmp/build --name=piper-some-name --cpu-shares=256 --privileged --volume=/tmp/local-1:/tmp/build/some/path/input --volume=/tmp/local-2:/tmp/build/some/path/output my-image:x.y my-task.sh", pathToDocker),
+		}))
+	})
+
+	It("overrides the container limits from the command line", func() {
+		command := exec.Command(pathToPiper,
+			"--dry-run",
+			"-c", "fixtures/advanced_task.yml",
+			"-i", "input=/tmp/local-1",
+			"-o", "output=/tmp/local-2",
+			"-cpu", "1024",
+			"-memory", "1GB")
+		session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+		Expect(err).NotTo(HaveOccurred())
+
+		Eventually(session).Should(gexec.Exit(0))
+
+		dockerCommands := splitInvocations(session.Out.Contents())
+		Expect(dockerCommands[1]).To(ContainSubstring("--cpu-shares=1024 --memory=1073741824 "))
+	})
+
+	It("logs in to the registry before pulling the image", func() {
+		Expect(os.RemoveAll(dockerconfig.RegistryConfigsPath)).To(Succeed())
+
+		command := exec.Command(pathToPiper, "-name", "piper-some-name", "-c", "fixtures/authenticated_task.yml")
+		session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+		Expect(err).NotTo(HaveOccurred())
+
+		Eventually(session).Should(gexec.Exit(0))
+
+		dockerInvocations, err := ioutil.ReadFile(dockerconfig.InvocationsPath)
+		Expect(err).NotTo(HaveOccurred())
+
+		dockerCommands := splitInvocations(dockerInvocations)
+		Expect(dockerCommands).To(Equal([]string{
+			fmt.Sprintf("%s login --username=my-user --password-stdin registry.example.com", pathToDocker),
+			fmt.Sprintf("%s pull registry.example.com/my-image@sha256:my-digest", pathToDocker),
+			fmt.Sprintf("%s run --workdir=/tmp/build --name=piper-some-name registry.example.com/my-image@sha256:my-digest my-task.sh", pathToDocker),
+		}))
+
+		registryConfigs, err := ioutil.ReadFile(dockerconfig.RegistryConfigsPath)
+		Expect(err).NotTo(HaveOccurred())
+
+		configDirs := splitInvocations(registryConfigs)
+		Expect(configDirs).To(HaveLen(2))
+		Expect(configDirs[0]).NotTo(BeEmpty())
+		Expect(configDirs[1]).To(Equal(configDirs[0]))
+		Expect(configDirs[0]).NotTo(BeADirectory())
+	})
+
+	It("prints the docker commands to stdout, but does not execute them", func() {
+		command := exec.Command(pathToPiper,
+			"--dry-run",
+			"-c", "fixtures/advanced_task.yml",
+			"-i", "input=/tmp/local-1",
+			"-o", "output=/tmp/local-2",
+			"-p")
+		session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+		Expect(err).NotTo(HaveOccurred())
+
+		Eventually(session).Should(gexec.Exit(0))
+
+		dockerCommands := splitInvocations(session.Out.Contents())
+		Expect(dockerCommands).To(Equal([]string{
+			fmt.Sprintf("%s pull my-image:x.y", pathToDocker),
+			fmt.Sprintf("%s run --workdir=/tmp/build --cpu-shares=256 --privileged --volume=/tmp/local-1:/tmp/build/some/path/input --volume=/tmp/local-2:/tmp/build/some/path/output my-image:x.y my-task.sh", pathToDocker),
+		}))
+		_, err = os.Stat(dockerconfig.InvocationsPath)
+		Expect(os.IsNotExist(err)).To(BeTrue())
+	})
+
+	It("prints the same docker commands on every dry-run", func() {
+		dryRun := func(args ...string) []byte {
+			command := exec.Command(pathToPiper, append([]string{"-dry-run", "-c", "fixtures/task_without_image.yml", "-r", "busybox"}, args...)...)
+			session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+			Expect(err).NotTo(HaveOccurred())
+
+			Eventually(session).Should(gexec.Exit(0))
+			return session.Out.Contents()
+		}
+
+		output := dryRun()
+		Expect(string(output)).NotTo(ContainSubstring("--name="))
+		Expect(dryRun()).To(Equal(output))
+
+		Expect(string(dryRun("-name", "piper-some-name"))).To(ContainSubstring(" --name=piper-some-name "))
+	})
+
+	It("pulls the image only when it is not present locally with -pull=missing", func() {
+		command := exec.Command(pathToPiper,
+			"-c", "fixtures/task.yml",
+			"-i", "input-1=/tmp/local-1",
+			"-o", "output-1=/tmp/local-2",
+			"-pull", "missing")
+		command.Env = append(os.Environ(), "VAR1=var-1", fmt.Sprintf("%s=my-image", dockerconfig.MissingImagesEnv))
+
+		session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+		Expect(err).NotTo(HaveOccurred())
+
+		Eventually(session).Should(gexec.Exit(0))
+
+		dockerInvocations, err := ioutil.ReadFile(dockerconfig.InvocationsPath)
+		Expect(err).NotTo(HaveOccurred())
+
+		dockerCommands := splitInvocations(dockerInvocations)
+		Expect(dockerCommands).To(HaveLen(3))
+		Expect(dockerCommands[:2]).To(Equal([]string{
+			fmt.Sprintf("%s image inspect --format={{.Id}} my-image", pathToDocker),
+			fmt.Sprintf("%s pull my-image", pathToDocker),
+		}))
+	})
+
+	It("prints that a present image is not pulled in dry-run", func() {
+		command := exec.Command(pathToPiper,
+			"-dry-run",
+			"-c", "fixtures/task.yml",
+			"-i", "input-1=/tmp/local-1",
+			"-o", "output-1=/tmp/local-2",
+			"-pull", "missing")
+		command.Env = append(os.Environ(), "VAR1=var-1")
+
+		session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+		Expect(err).NotTo(HaveOccurred())
+
+		Eventually(session).Should(gexec.Exit(0))
+
+		output := splitInvocations(session.Out.Contents())
+		Expect(output).To(HaveLen(2))
+		Expect(output[0]).To(Equal("# not pulling my-image: it is present locally (-pull=missing)"))
+		Expect(output[1]).To(HavePrefix(fmt.Sprintf("%s run ", pathToDocker)))
+
+		dockerInvocations, err := ioutil.ReadFile(dockerconfig.InvocationsPath)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(splitInvocations(dockerInvocations)).To(Equal([]string{
+			fmt.Sprintf("%s image inspect --format={{.Id}} my-image", pathToDocker),
+		}))
+	})
+
+	It("retries pulling the image after a network error", func() {
+		command := exec.Command(pathToPiper,
+			"-c", "fixtures/task.yml",
+			"-i", "input-1=/tmp/local-1",
+			"-o", "output-1=/tmp/local-2",
+			"-pull-backoff", "10ms")
+		command.Env = append(os.Environ(),
+			"VAR1=var-1",
+			fmt.Sprintf("%s=2", dockerconfig.PullFailuresEnv),
+			fmt.Sprintf(`%s=Error response from daemon: Get "https://registry-1.docker.io/v2/": dial tcp: i/o timeout`, dockerconfig.PullErrorEnv))
+
+		session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+		Expect(err).NotTo(HaveOccurred())
+
+		Eventually(session).Should(gexec.Exit(0))
+		Expect(session.Err.Contents()).To(ContainSubstring("could not pull my-image: network error, retrying in 10ms (1 of 3 retries)"))
+		Expect(session.Err.Contents()).To(ContainSubstring("could not pull my-image: network error, retrying in 20ms (2 of 3 retries)"))
+
+		dockerInvocations, err := ioutil.ReadFile(dockerconfig.InvocationsPath)
+		Expect(err).NotTo(HaveOccurred())
+
+		dockerCommands := splitInvocations(dockerInvocations)
+		Expect(dockerCommands).To(HaveLen(4))
+		Expect(dockerCommands[:3]).To(Equal([]string{
+			fmt.Sprintf("%s pull my-image", pathToDocker),
+			fmt.Sprintf("%s pull my-image", pathToDocker),
+			fmt.Sprintf("%s pull my-image", pathToDocker),
+		}))
+	})
+
+	It("runs a concourse task read from stdin", func() {
+		taskConfig, err := ioutil.ReadFile("fixtures/task.yml")
+		Expect(err).NotTo(HaveOccurred())
+
+		command := exec.Command(pathToPiper,
+			"-name", "piper-some-name",
+			"-c", "-",
+			"-i", "input-1=/tmp/local-1",
+			"-o", "output-1=/tmp/local-2",
+		)
+		command.Stdin = strings.NewReader(string(taskConfig))
+
+		session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+		Expect(err).NotTo(HaveOccurred())
+
+		Eventually(session).Should(gexec.Exit(0))
+
+		dockerInvocations, err := ioutil.ReadFile(dockerconfig.InvocationsPath)
+		Expect(err).NotTo(HaveOccurred())
+
+		dockerCommands := splitInvocations(dockerInvocations)
+		Expect(dockerCommands).To(Equal([]string{
+			fmt.Sprintf("%s pull my-image", pathToDocker),
+			fmt.Sprintf("%s run --workdir=/tmp/build --name=piper-some-name --env=VAR1=default-var-1 --volume=/tmp/local-1:/tmp/build/input-1 --volume=/tmp/local-2:/tmp/build/output-1 my-image my-task.sh", pathToDocker),
+		}))
+	})
+
+	It("runs an inline task from a pipeline job", func() {
+		command := exec.Command(pathToPiper,
+			"--dry-run",
+			"-pipeline", "fixtures/pipeline.yml",
+			"-job", "my-job",
+			"-task", "inline-task",
+			"-i", "input-1=/tmp/local-1",
+		)
+
+		session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+		Expect(err).NotTo(HaveOccurred())
+
+		Eventually(session).Should(gexec.Exit(0))
+
+		dockerCommands := splitInvocations(session.Out.Contents())
+		Expect(dockerCommands[0]).To(Equal(fmt.Sprintf("%s pull my-image", pathToDocker)))
+		Expect(dockerCommands[1]).To(HavePrefix(fmt.Sprintf("%s run --workdir=/tmp/build --privileged ", pathToDocker)))
+		Expect(dockerCommands[1]).To(ContainSubstring("--env=VAR1=default-var-1"))
+		Expect(dockerCommands[1]).To(ContainSubstring("--env=VAR2=step-var-2"))
+		Expect(dockerCommands[1]).To(HaveSuffix("--volume=/tmp/local-1:/tmp/build/input-1 my-image my-task.sh"))
+	})
+
+	It("runs a task file referenced from a pipeline job", func() {
+		command := exec.Command(pathToPiper,
+			"--dry-run",
+			"-pipeline", "fixtures/pipeline.yml",
+			"-job", "my-job",
+			"-task", "file-task",
+			"-i", "repo=fixtures",
+			"-o", "output-1=/tmp/local-2",
+		)
+
+		session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+		Expect(err).NotTo(HaveOccurred())
+
+		Eventually(session).Should(gexec.Exit(0))
+
+		dockerCommands := splitInvocations(session.Out.Contents())
+		Expect(dockerCommands).To(Equal([]string{
+			fmt.Sprintf("%s pull my-image", pathToDocker),
+			fmt.Sprintf("%s run --workdir=/tmp/build --env=VAR1=default-var-1 --volume=fixtures:/tmp/build/input-1 --volume=/tmp/local-2:/tmp/build/output-1 my-image my-task.sh", pathToDocker),
+		}))
+	})
+
+	It("runs a concourse task with mapped inputs and outputs", func() {
+		command := exec.Command(pathToPiper,
+			"--dry-run",
+			"-c", "fixtures/task.yml",
+			"-input-mapping", "input-1=repo",
+			"-output-mapping", "output-1=built-repo",
+			"-i", "repo=/tmp/local-1",
+			"-o", "built-repo=/tmp/local-2",
+		)
+
+		session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+		Expect(err).NotTo(HaveOccurred())
+
+		Eventually(session).Should(gexec.Exit(0))
+
+		dockerCommands := splitInvocations(session.Out.Contents())
+		Expect(dockerCommands[1]).To(Equal(fmt.Sprintf("%s run --workdir=/tmp/build --env=VAR1=default-var-1 --volume=/tmp/local-1:/tmp/build/input-1 --volume=/tmp/local-2:/tmp/build/output-1 my-image my-task.sh", pathToDocker)))
+	})
+
+	It("interpolates vars into the task config and prints them", func() {
+		command := exec.Command(pathToPiper,
+			"--dry-run",
+			"-c", "fixtures/task_with_vars.yml",
+			"-l", "fixtures/vars.yml",
+			"-v", "tag=1.7",
+			"-y", "retries=3",
+		)
+
+		session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+		Expect(err).NotTo(HaveOccurred())
+
+		Eventually(session).Should(gexec.Exit(0))
+
+		dockerCommands := splitInvocations(session.Out.Contents())
+		Expect(dockerCommands[:5]).To(Equal([]string{
+			"# ((creds.user)) = my-user",
+			"# ((image)) = my-image",
+			"# ((retries)) = 3",
+			"# ((tag)) = 1.7",
+			fmt.Sprintf("%s pull my-image:1.7", pathToDocker),
+		}))
+		Expect(dockerCommands[5]).To(ContainSubstring("--env=RETRIES=3"))
+		Expect(dockerCommands[5]).To(ContainSubstring("--env=USER=my-user"))
+	})
+
+	It("resolves secret vars from credential managers and keeps them out of the dry-run output", func() {
+		command := exec.Command(pathToPiper,
+			"--dry-run",
+			"-c", "fixtures/task_with_vars.yml",
+			"-l", "fixtures/vars.yml",
+			"-v", "tag=1.7",
+			"-env-vars-prefix", "PIPER_VAR_",
+		)
+		command.Env = append(os.Environ(), "PIPER_VAR_RETRIES=some-secret-retries")
+
+		session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+		Expect(err).NotTo(HaveOccurred())
+
+		Eventually(session).Should(gexec.Exit(0))
+
+		Expect(string(session.Out.Contents())).To(ContainSubstring("# ((retries)) = [redacted]\n"))
+		Expect(string(session.Out.Contents())).To(ContainSubstring("--env=RETRIES=[redacted]"))
+		Expect(string(session.Out.Contents())).NotTo(ContainSubstring("some-secret-retries"))
+	})
+
+	It("masks short secret vars only where their value is printed in the dry-run output", func() {
+		command := exec.Command(pathToPiper,
+			"--dry-run",
+			"-c", "fixtures/task_with_vars.yml",
+			"-l", "fixtures/vars.yml",
+			"-v", "tag=1.7",
+			"-env-vars-prefix", "PIPER_VAR_",
+		)
+		command.Env = append(os.Environ(), "PIPER_VAR_RETRIES=1")
+
+		session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+		Expect(err).NotTo(HaveOccurred())
+
+		Eventually(session).Should(gexec.Exit(0))
+
+		Expect(string(session.Out.Contents())).To(ContainSubstring("# ((retries)) = [redacted]\n"))
+		Expect(string(session.Out.Contents())).To(ContainSubstring("# ((tag)) = 1.7\n"))
+		Expect(string(session.Out.Contents())).To(ContainSubstring("--env=RETRIES=[redacted]"))
+		Expect(string(session.Out.Contents())).To(ContainSubstring("my-image:1.7"))
+	})
+
+	It("masks env values from secret vars when printing the env", func() {
+		command := exec.Command(pathToPiper,
+			"-c", "fixtures/task_with_vars.yml",
+			"-l", "fixtures/vars.yml",
+			"-v", "tag=1.7",
+			"-env-vars-prefix", "PIPER_VAR_",
+			"-print-env",
+		)
+		command.Env = append(os.Environ(), "PIPER_VAR_RETRIES=some-secret-retries")
+
+		session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+		Expect(err).NotTo(HaveOccurred())
+
+		Eventually(session).Should(gexec.Exit(0))
+
+		Expect(string(session.Out.Contents())).To(ContainSubstring("# env RETRIES=[redacted] (task)\n"))
+		Expect(string(session.Out.Contents())).To(ContainSubstring("# env USER=my-user (task)\n"))
+		Expect(string(session.Out.Contents())).NotTo(ContainSubstring("some-secret-retries"))
+
+		dockerInvocations, err := ioutil.ReadFile(dockerconfig.InvocationsPath)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(dockerInvocations)).To(ContainSubstring("--env=RETRIES=some-secret-retries"))
+	})
+
+	It("masks secret env values in the dry-run output", func() {
+		command := exec.Command(pathToPiper,
+			"--dry-run",
+			"-c", "fixtures/task_with_secrets.yml",
+			"-redact",
+		)
+
+		session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+		Expect(err).NotTo(HaveOccurred())
+
+		Eventually(session).Should(gexec.Exit(0))
+
+		Expect(string(session.Out.Contents())).To(ContainSubstring("--env=GITHUB_TOKEN=[redacted]"))
+		Expect(string(session.Out.Contents())).To(ContainSubstring("--env=API_KEY=[redacted]"))
+		Expect(string(session.Out.Contents())).To(ContainSubstring("--env=NAME=my-name"))
+	})
+
+	It("prints env vars in a stable order", func() {
+		command := exec.Command(pathToPiper,
+			"--dry-run",
+			"-c", "fixtures/task_with_secrets.yml",
+		)
+
+		session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+		Expect(err).NotTo(HaveOccurred())
+
+		Eventually(session).Should(gexec.Exit(0))
+
+		Expect(string(session.Out.Contents())).To(ContainSubstring("--env=API_KEY=my-api-key --env=GITHUB_TOKEN=my-github-token --env=NAME=my-name"))
+	})
+
+	It("sets env vars from flags, env files and the host, and prints where they came from", func() {
+		command := exec.Command(pathToPiper,
+			"--dry-run",
+			"-c", "fixtures/task_with_secrets.yml",
+			"-env-file", "fixtures/task.env",
+			"-e", "API_KEY=from-flag",
+			"-pass-env", "PIPER_TEST_*",
+			"-print-env",
+			"-redact",
+		)
+		command.Env = append(os.Environ(), "PIPER_TEST_PASSED=passed", "GITHUB_TOKEN=from-host")
+
+		session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+		Expect(err).NotTo(HaveOccurred())
+
+		Eventually(session).Should(gexec.Exit(0))
+
+		Expect(string(session.Out.Contents())).To(ContainSubstring(strings.Join([]string{
+			"# env API_KEY=[redacted] (flag)",
+			"# env EXTRA=extra (env-file)",
+			"# env GITHUB_TOKEN=[redacted] (host)",
+			"# env NAME=from-env-file (env-file)",
+			"# env PIPER_TEST_PASSED=passed (host)",
+		}, "\n")))
+		Expect(string(session.Out.Contents())).To(ContainSubstring("--env=API_KEY=[redacted] --env=EXTRA=extra --env=GITHUB_TOKEN=[redacted] --env=NAME=from-env-file --env=PIPER_TEST_PASSED=passed"))
+	})
+
+	It("overrides params with empty host env vars and leaves out unset ones", func() {
+		command := exec.Command(pathToPiper,
+			"--dry-run",
+			"-c", "fixtures/task_with_secrets.yml",
+			"-unset-env", "GITHUB_TOKEN",
+		)
+		command.Env = append(os.Environ(), "NAME=")
+
+		session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+		Expect(err).NotTo(HaveOccurred())
+
+		Eventually(session).Should(gexec.Exit(0))
+
+		Expect(string(session.Out.Contents())).To(ContainSubstring("--env=API_KEY=my-api-key --env=NAME= my-image"))
+	})
+
+	It("warns about params that are left empty", func() {
+		command := exec.Command(pathToPiper,
+			"-c", "fixtures/task_with_required_params.yml",
+			"-e", "DB_URL=postgres://db",
+		)
+
+		session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+		Expect(err).NotTo(HaveOccurred())
+
+		Eventually(session).Should(gexec.Exit(0))
+		Expect(string(session.Err.Contents())).To(ContainSubstring("warning: the following params are empty: API_KEY\n"))
+	})
+
+	It("prints the docker engine API requests in dry-run", func() {
+		command := exec.Command(pathToPiper,
+			"--dry-run",
+			"-docker-api",
+			"-c", "fixtures/task_with_secrets.yml",
+		)
+		command.Env = append(os.Environ(), "DOCKER_HOST=unix:///no/such/docker.sock")
+
+		session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+		Expect(err).NotTo(HaveOccurred())
+
+		Eventually(session).Should(gexec.Exit(0))
+
+		Expect(string(session.Out.Contents())).To(ContainSubstring("POST /v1.41/images/create?fromImage=my-image&tag=latest\n"))
+		Expect(string(session.Out.Contents())).To(ContainSubstring(`POST /v1.41/containers/create {"Image":"my-image"`))
+	})
+
+	It("passes env vars to docker in an env file", func() {
+		command := exec.Command(pathToPiper,
+			"-name", "piper-some-name",
+			"-c", "fixtures/task_with_secrets.yml",
+			"-use-env-file",
+		)
+
 		session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
 		Expect(err).NotTo(HaveOccurred())
 
@@ -83,68 +764,319 @@ var _ = Describe("Piper", func() {
 		dockerInvocations, err := ioutil.ReadFile(dockerconfig.InvocationsPath)
 		Expect(err).NotTo(HaveOccurred())
 
-		dockerCommands := strings.Split(strings.TrimSpace(string(dockerInvocations)), "\n")
-		Expect(dockerCommands).To(Equal([]string{
-			fmt.Sprintf("%s pull my-image:x.y", pathToDocker),
-			fmt.Sprintf("%s run --workdir=/tmp/build --privileged --volume=/tmp/local-1:/tmp/build/some/path/input --volume=/tmp/local-2:/tmp/build/some/path/output --tty my-image:x.y my-task.sh", pathToDocker),
-		}))
+		dockerCommands := splitInvocations(dockerInvocations)
+		Expect(dockerCommands).To(HaveLen(2))
+		Expect(dockerCommands[1]).To(MatchRegexp(`docker run --workdir=/tmp/build --name=piper-some-name --env-file=\S+ my-image my-task.sh$`))
+		Expect(dockerCommands[1]).NotTo(ContainSubstring("my-github-token"))
 	})
 
-	It("prints the docker commands to stdout, but does not execute them", func() {
+	It("prints the redacted env file in the dry-run output", func() {
 		command := exec.Command(pathToPiper,
 			"--dry-run",
-			"-c", "fixtures/advanced_task.yml",
-			"-i", "input=/tmp/local-1",
-			"-o", "output=/tmp/local-2",
-			"-p")
+			"-c", "fixtures/task_with_secrets.yml",
+			"-use-env-file",
+			"-redact",
+		)
+
 		session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
 		Expect(err).NotTo(HaveOccurred())
 
 		Eventually(session).Should(gexec.Exit(0))
 
-		dockerCommands := strings.Split(strings.TrimSpace(string(session.Out.Contents())), "\n")
-		Expect(dockerCommands).To(Equal([]string{
-			fmt.Sprintf("%s pull my-image:x.y", pathToDocker),
-			fmt.Sprintf("%s run --workdir=/tmp/build --privileged --volume=/tmp/local-1:/tmp/build/some/path/input --volume=/tmp/local-2:/tmp/build/some/path/output --tty my-image:x.y my-task.sh", pathToDocker),
-		}))
+		Expect(string(session.Out.Contents())).To(ContainSubstring("--env-file=<env-file>"))
+		Expect(string(session.Out.Contents())).To(ContainSubstring("# GITHUB_TOKEN=[redacted]\n"))
+		Expect(string(session.Out.Contents())).To(ContainSubstring("# NAME=my-name\n"))
+	})
+
+	It("masks env values for keys marked secret in the piper config", func() {
+		command := exec.Command(pathToPiper,
+			"--dry-run",
+			"-c", "fixtures/task_with_secrets.yml",
+			"-piper-config", "fixtures/piper.yml",
+		)
+
+		session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+		Expect(err).NotTo(HaveOccurred())
+
+		Eventually(session).Should(gexec.Exit(0))
+
+		Expect(string(session.Out.Contents())).To(ContainSubstring("--env=API_KEY=[redacted]"))
+		Expect(string(session.Out.Contents())).To(ContainSubstring("--env=GITHUB_TOKEN=my-github-token"))
+	})
+
+	It("validates the task config without running it", func() {
+		command := exec.Command(pathToPiper, "-validate", "-c", "fixtures/advanced_task.yml")
+		session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+		Expect(err).NotTo(HaveOccurred())
+
+		Eventually(session).Should(gexec.Exit(0))
+		Expect(string(session.Out.Contents())).To(Equal("fixtures/advanced_task.yml: task config is valid\n"))
+
 		_, err = os.Stat(dockerconfig.InvocationsPath)
 		Expect(os.IsNotExist(err)).To(BeTrue())
 	})
 
+	It("validates a task config without an image when -r is given", func() {
+		command := exec.Command(pathToPiper, "-validate", "-c", "fixtures/task_without_image.yml", "-r", "busybox")
+		session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+		Expect(err).NotTo(HaveOccurred())
+
+		Eventually(session).Should(gexec.Exit(0))
+		Expect(string(session.Out.Contents())).To(Equal("fixtures/task_without_image.yml: task config is valid\n"))
+	})
+
+	Context("when piper is interrupted while the task is running", func() {
+		runTask := func(args ...string) *gexec.Session {
+			command := exec.Command(pathToPiper, append([]string{
+				"-c", "fixtures/task.yml",
+				"-i", "input-1=/tmp/local-1",
+				"-o", "output-1=/tmp/local-2",
+			}, args...)...)
+			command.Env = append(os.Environ(), fmt.Sprintf("%s=1m", dockerconfig.RunDurationEnv))
+
+			session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+			Expect(err).NotTo(HaveOccurred())
+
+			Eventually(func() string {
+				dockerInvocations, _ := ioutil.ReadFile(dockerconfig.InvocationsPath)
+				return string(dockerInvocations)
+			}).Should(ContainSubstring(" run "))
+
+			return session
+		}
+
+		It("stops and removes the container and exits like a shell on Ctrl-C", func() {
+			session := runTask("-rm")
+			session.Interrupt()
+
+			Eventually(session).Should(gexec.Exit(130))
+			Expect(session.Err.Contents()).To(ContainSubstring("stopped the task after receiving interrupt"))
+
+			dockerInvocations, err := ioutil.ReadFile(dockerconfig.InvocationsPath)
+			Expect(err).NotTo(HaveOccurred())
+
+			dockerCommands := splitInvocations(dockerInvocations)
+			Expect(dockerCommands).To(HaveLen(4))
+			Expect(dockerCommands[1]).To(MatchRegexp(`^%s run --workdir=/tmp/build --name=piper-[0-9a-f]{12} --rm `, regexp.QuoteMeta(pathToDocker)))
+
+			containerName := regexp.MustCompile(`--name=(\S+)`).FindStringSubmatch(dockerCommands[1])[1]
+			Expect(dockerCommands[2:]).To(Equal([]string{
+				fmt.Sprintf("%s stop --time=10 %s", pathToDocker, containerName),
+				fmt.Sprintf("%s rm --force %s", pathToDocker, containerName),
+			}))
+		})
+
+		It("stops the container with the given grace period on SIGTERM", func() {
+			session := runTask("-stop-grace-period", "3s", "-name", "piper-some-name")
+			session.Terminate()
+
+			Eventually(session).Should(gexec.Exit(143))
+
+			dockerInvocations, err := ioutil.ReadFile(dockerconfig.InvocationsPath)
+			Expect(err).NotTo(HaveOccurred())
+
+			dockerCommands := splitInvocations(dockerInvocations)
+			Expect(dockerCommands).To(HaveLen(3))
+			Expect(dockerCommands[2]).To(Equal(fmt.Sprintf("%s stop --time=3 piper-some-name", pathToDocker)))
+		})
+	})
+
 	Context("failure cases", func() {
+		Context("when params are left empty with -strict-params", func() {
+			It("prints every empty param and exits with the parse error status before pulling the image", func() {
+				command := exec.Command(pathToPiper, "-strict-params", "-c", "fixtures/task_with_required_params.yml")
+				session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+				Expect(err).NotTo(HaveOccurred())
+
+				Eventually(session).Should(gexec.Exit(121))
+				Expect(string(session.Err.Contents())).To(ContainSubstring("The following params are required but not set: API_KEY, DB_URL."))
+				Expect(dockerconfig.InvocationsPath).NotTo(BeAnExistingFile())
+			})
+		})
+
+		Context("when the task config is not valid", func() {
+			It("prints each error with its location and exits with the parse error status", func() {
+				command := exec.Command(pathToPiper, "-validate", "-c", "fixtures/invalid_task.yml")
+				session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+				Expect(err).NotTo(HaveOccurred())
+
+				Eventually(session).Should(gexec.Exit(121))
+				Expect(string(session.Err.Contents())).To(Equal(`fixtures/invalid_task.yml: line 5, column 3: missing required field run.path
+fixtures/invalid_task.yml: line 7, column 1: unknown field "ouputs"
+`))
+			})
+		})
+
+		Context("when the task has no image and -r is not given", func() {
+			It("prints an error and exits with the parse error status", func() {
+				command := exec.Command(pathToPiper, "-c", "fixtures/task_without_image.yml")
+				session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+				Expect(err).NotTo(HaveOccurred())
+
+				Eventually(session).Should(gexec.Exit(121))
+				Expect(session.Err.Contents()).To(ContainSubstring("line 2, column 1: missing image: one of image, rootfs_uri or image_resource is required"))
+			})
+
+			It("fails validation with the location of the error", func() {
+				command := exec.Command(pathToPiper, "-validate", "-c", "fixtures/task_without_image.yml")
+				session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+				Expect(err).NotTo(HaveOccurred())
+
+				Eventually(session).Should(gexec.Exit(121))
+				Expect(string(session.Err.Contents())).To(Equal("fixtures/task_without_image.yml: line 2, column 1: missing image: one of image, rootfs_uri or image_resource is required\n"))
+			})
+		})
+
+		Context("when the pipeline job cannot be found", func() {
+			It("prints an error and exits with the parse error status", func() {
+				command := exec.Command(pathToPiper, "-pipeline", "fixtures/pipeline.yml", "-job", "no-such-job", "-task", "inline-task")
+				session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+				Expect(err).NotTo(HaveOccurred())
+
+				Eventually(session).Should(gexec.Exit(121))
+				Expect(session.Err.Contents()).To(ContainSubstring(`could not find job "no-such-job" in pipeline fixtures/pipeline.yml`))
+			})
+		})
+
+		Context("when -pipeline is passed without -job and -task", func() {
+			It("prints an error and exits with the parse error status", func() {
+				command := exec.Command(pathToPiper, "-pipeline", "fixtures/pipeline.yml")
+				session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+				Expect(err).NotTo(HaveOccurred())
+
+				Eventually(session).Should(gexec.Exit(121))
+				Expect(session.Err.Contents()).To(ContainSubstring("-job and -task are required with -pipeline"))
+			})
+		})
+
+		Context("when a var is undefined", func() {
+			It("prints the location of the var and exits with the parse error status", func() {
+				command := exec.Command(pathToPiper, "-validate", "-c", "fixtures/task_with_vars.yml", "-l", "fixtures/vars.yml")
+				session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+				Expect(err).NotTo(HaveOccurred())
+
+				Eventually(session).Should(gexec.Exit(121))
+				Expect(string(session.Err.Contents())).To(Equal(`fixtures/task_with_vars.yml: line 2, column 8: undefined variable ((tag))
+fixtures/task_with_vars.yml: line 9, column 12: undefined variable ((retries))
+`))
+			})
+		})
+
 		Context("when the flag is not passed in", func() {
-			It("Print an error and exit with status 1", func() {
+			It("prints an error and exits with the parse error status", func() {
 				command := exec.Command(pathToPiper)
 				session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
 				Expect(err).NotTo(HaveOccurred())
 
-				Eventually(session).Should(gexec.Exit(1))
+				Eventually(session).Should(gexec.Exit(121))
 				Expect(session.Err.Contents()).To(ContainSubstring("-c is a required flag"))
 			})
 		})
 
+		Context("when the image is not present locally with -offline", func() {
+			It("prints the missing image and exits with the pull error status before running the task", func() {
+				command := exec.Command(pathToPiper,
+					"-c", "fixtures/task.yml",
+					"-i", "input-1=/tmp/local-1",
+					"-o", "output-1=/tmp/local-2",
+					"-offline")
+				command.Env = append(os.Environ(), fmt.Sprintf("%s=my-image", dockerconfig.MissingImagesEnv))
+				session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+				Expect(err).NotTo(HaveOccurred())
+
+				Eventually(session).Should(gexec.Exit(123))
+				Expect(session.Err.Contents()).To(ContainSubstring("cannot run offline: the following images are not present locally: my-image"))
+
+				dockerInvocations, err := ioutil.ReadFile(dockerconfig.InvocationsPath)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(splitInvocations(dockerInvocations)).To(Equal([]string{
+					fmt.Sprintf("%s image inspect --format={{.Id}} my-image", pathToDocker),
+				}))
+			})
+		})
+
+		Context("when -offline and -pull=always are passed in", func() {
+			It("prints an error and exits with the parse error status", func() {
+				command := exec.Command(pathToPiper, "-c", "fixtures/task.yml", "-offline", "-pull", "always")
+				session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+				Expect(err).NotTo(HaveOccurred())
+
+				Eventually(session).Should(gexec.Exit(121))
+				Expect(session.Err.Contents()).To(ContainSubstring("-offline and -pull=always cannot be used together"))
+			})
+		})
+
+		Context("when the pull policy is not supported", func() {
+			It("prints an error and exits with the parse error status", func() {
+				command := exec.Command(pathToPiper, "-c", "fixtures/task.yml", "-pull", "sometimes")
+				session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+				Expect(err).NotTo(HaveOccurred())
+
+				Eventually(session).Should(gexec.Exit(121))
+				Expect(session.Err.Contents()).To(ContainSubstring(`unsupported pull policy "sometimes": must be one of always, missing, never`))
+			})
+		})
+
+		Context("when a flag is not defined", func() {
+			It("prints an error and exits with the parse error status", func() {
+				command := exec.Command(pathToPiper, "-c", "fixtures/task.yml", "-no-such-flag")
+				session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+				Expect(err).NotTo(HaveOccurred())
+
+				Eventually(session).Should(gexec.Exit(121))
+				Expect(session.Err.Contents()).To(ContainSubstring("flag provided but not defined: -no-such-flag"))
+				Expect(dockerconfig.InvocationsPath).NotTo(BeAnExistingFile())
+			})
+		})
+
+		Context("when both -user and -host-user are passed in", func() {
+			It("prints an error and exits with the parse error status", func() {
+				command := exec.Command(pathToPiper, "-c", "fixtures/task.yml", "-user", "some-user", "-host-user")
+				session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+				Expect(err).NotTo(HaveOccurred())
+
+				Eventually(session).Should(gexec.Exit(121))
+				Expect(session.Err.Contents()).To(ContainSubstring("-user and -host-user cannot be used together"))
+			})
+		})
+
 		Context("when the task file does not exist", func() {
-			It("prints an error and exits 1", func() {
+			It("prints an error and exits with the parse error status", func() {
 				command := exec.Command(pathToPiper, "-c", "no-such-file")
 				session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
 				Expect(err).NotTo(HaveOccurred())
 
-				Eventually(session).Should(gexec.Exit(1))
+				Eventually(session).Should(gexec.Exit(121))
 				Expect(session.Err.Contents()).To(ContainSubstring("no such file or directory"))
 			})
 		})
 
 		Context("when inputs are missing", func() {
-			It("prints an error and exits 1", func() {
+			It("prints an error and exits with the mount error status", func() {
 				command := exec.Command(pathToPiper, "-c", "fixtures/task.yml")
 				session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
 				Expect(err).NotTo(HaveOccurred())
 
-				Eventually(session).Should(gexec.Exit(1))
+				Eventually(session).Should(gexec.Exit(122))
 				Expect(session.Err.Contents()).To(ContainSubstring("The following required inputs/outputs are not satisfied: input-1, output-1."))
 			})
 		})
 
+		Context("when the runtime is not supported", func() {
+			It("prints an error and exits with the runtime error status", func() {
+				command := exec.Command(pathToPiper,
+					"-c", "fixtures/task.yml",
+					"-i", "input-1=/tmp/local-1",
+					"-o", "output-1=/tmp/local-2",
+					"-runtime", "rkt")
+				session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+				Expect(err).NotTo(HaveOccurred())
+
+				Eventually(session).Should(gexec.Exit(124))
+				Expect(session.Err.Contents()).To(ContainSubstring(`unsupported runtime "rkt": must be one of docker, podman, nerdctl`))
+			})
+		})
+
 		Context("when docker cannot be found on the $PATH", func() {
 			var path string
 
@@ -157,7 +1089,7 @@ var _ = Describe("Piper", func() {
 				os.Setenv("PATH", path)
 			})
 
-			It("prints an error and exits 1", func() {
+			It("prints an error and exits with the runtime error status", func() {
 				command := exec.Command(pathToPiper,
 					"-c", "fixtures/task.yml",
 					"-i", "input-1=/tmp/local-1",
@@ -165,11 +1097,53 @@ var _ = Describe("Piper", func() {
 				session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
 				Expect(err).NotTo(HaveOccurred())
 
-				Eventually(session).Should(gexec.Exit(1))
+				Eventually(session).Should(gexec.Exit(124))
 				Expect(session.Err.Contents()).To(ContainSubstring("executable file not found in $PATH"))
 			})
 		})
 
+		Context("when the task exits with a non-zero status", func() {
+			It("exits with the status of the task", func() {
+				command := exec.Command(pathToPiper,
+					"-c", "fixtures/task.yml",
+					"-i", "input-1=/tmp/local-1",
+					"-o", "output-1=/tmp/local-2")
+				command.Env = append(os.Environ(), fmt.Sprintf("%s=3", dockerconfig.RunExitStatusEnv))
+				session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+				Expect(err).NotTo(HaveOccurred())
+
+				Eventually(session).Should(gexec.Exit(3))
+				Expect(session.Err.Contents()).To(ContainSubstring("task exited with status 3"))
+			})
+		})
+
+		Context("when the task fails with -keep-on-failure", func() {
+			It("keeps the container and prints how to intercept it", func() {
+				command := exec.Command(pathToPiper,
+					"-c", "fixtures/task.yml",
+					"-i", "input-1=/tmp/local-1",
+					"-o", "output-1=/tmp/local-2",
+					"-name", "piper-some-name",
+					"-keep-on-failure",
+					"-rm")
+				command.Env = append(os.Environ(), fmt.Sprintf("%s=3", dockerconfig.RunExitStatusEnv))
+				session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+				Expect(err).NotTo(HaveOccurred())
+
+				Eventually(session).Should(gexec.Exit(3))
+				Expect(session.Err.Contents()).To(ContainSubstring("task exited with status 3"))
+				Expect(session.Err.Contents()).To(ContainSubstring("kept container piper-some-name, open a shell in it with: piper intercept piper-some-name"))
+
+				dockerInvocations, err := ioutil.ReadFile(dockerconfig.InvocationsPath)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(splitInvocations(dockerInvocations)).To(Equal([]string{
+					fmt.Sprintf("%s pull my-image", pathToDocker),
+					fmt.Sprintf("%s run --workdir=/tmp/build --name=piper-some-name --env=VAR1=default-var-1 --volume=/tmp/local-1:/tmp/build/input-1 --volume=/tmp/local-2:/tmp/build/output-1 my-image my-task.sh", pathToDocker),
+				}))
+			})
+		})
+
 		Context("when docker fails to pull the image", func() {
 			var pathToBadDocker, path string
 
@@ -186,7 +1160,7 @@ var _ = Describe("Piper", func() {
 				os.Setenv("PATH", path)
 			})
 
-			It("prints an error and exits 1", func() {
+			It("prints an error and exits with the pull error status", func() {
 				command := exec.Command(pathToPiper,
 					"-c", "fixtures/task.yml",
 					"-i", "input-1=/tmp/local-1",
@@ -194,11 +1168,34 @@ var _ = Describe("Piper", func() {
 				session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
 				Expect(err).NotTo(HaveOccurred())
 
-				Eventually(session).Should(gexec.Exit(1))
+				Eventually(session).Should(gexec.Exit(123))
 				Expect(session.Err.Contents()).To(ContainSubstring("failed to pull"))
 			})
 		})
 
+		Context("when the registry denies access to the image", func() {
+			It("prints what to fix and exits with the pull error status without retrying", func() {
+				command := exec.Command(pathToPiper,
+					"-c", "fixtures/task.yml",
+					"-i", "input-1=/tmp/local-1",
+					"-o", "output-1=/tmp/local-2")
+				command.Env = append(os.Environ(),
+					fmt.Sprintf("%s=1", dockerconfig.PullFailuresEnv),
+					fmt.Sprintf("%s=Error response from daemon: unauthorized: authentication required", dockerconfig.PullErrorEnv))
+				session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+				Expect(err).NotTo(HaveOccurred())
+
+				Eventually(session).Should(gexec.Exit(123))
+				Expect(session.Err.Contents()).To(ContainSubstring("could not pull my-image: auth denied (check the registry credentials): Error response from daemon: unauthorized: authentication required"))
+
+				dockerInvocations, err := ioutil.ReadFile(dockerconfig.InvocationsPath)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(splitInvocations(dockerInvocations)).To(Equal([]string{
+					fmt.Sprintf("%s pull my-image", pathToDocker),
+				}))
+			})
+		})
+
 		Context("when docker fails to run the command", func() {
 			var pathToBadDocker, path string
 
@@ -215,7 +1212,7 @@ var _ = Describe("Piper", func() {
 				os.Setenv("PATH", path)
 			})
 
-			It("prints an error and exits 1", func() {
+			It("prints an error and exits with the runtime error status", func() {
 				command := exec.Command(pathToPiper,
 					"-c", "fixtures/task.yml",
 					"-i", "input-1=/tmp/local-1",
@@ -223,9 +1220,22 @@ var _ = Describe("Piper", func() {
 				session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
 				Expect(err).NotTo(HaveOccurred())
 
-				Eventually(session).Should(gexec.Exit(1))
+				Eventually(session).Should(gexec.Exit(124))
 				Expect(session.Err.Contents()).To(ContainSubstring("failed to run"))
 			})
+
+			It("does not print how to intercept a container that may not exist with -keep-on-failure", func() {
+				command := exec.Command(pathToPiper,
+					"-c", "fixtures/task.yml",
+					"-i", "input-1=/tmp/local-1",
+					"-o", "output-1=/tmp/local-2",
+					"-keep-on-failure")
+				session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+				Expect(err).NotTo(HaveOccurred())
+
+				Eventually(session).Should(gexec.Exit(124))
+				Expect(session.Err.Contents()).NotTo(ContainSubstring("kept container"))
+			})
 		})
 	})
 })
@@ -0,0 +1,41 @@
+package piper
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"gopkg.in/yaml.v3"
+)
+
+const DefaultPiperConfigPath = ".piper.yml"
+
+type RedactConfig struct {
+	Patterns []string `yaml:"patterns"`
+	Secrets  []string `yaml:"secrets"`
+}
+
+// PiperConfig configures piper itself, as opposed to the task it runs.
+type PiperConfig struct {
+	Redact *RedactConfig `yaml:"redact"`
+}
+
+type PiperConfigParser struct{}
+
+func (p PiperConfigParser) Parse(path string) (PiperConfig, error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return PiperConfig{}, err
+	}
+
+	var config PiperConfig
+	decoder := yaml.NewDecoder(bytes.NewReader(contents))
+	decoder.KnownFields(true)
+	err = decoder.Decode(&config)
+	if err != nil && err != io.EOF {
+		return PiperConfig{}, fmt.Errorf("could not parse piper config %s: %s", path, err)
+	}
+
+	return config, nil
+}
@@ -0,0 +1,69 @@
+package piper_test
+
+import (
+	"io/ioutil"
+	"os"
+
+	"github.com/ryanmoran/piper"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("PiperConfigParser", func() {
+	var configFilePath string
+
+	BeforeEach(func() {
+		tempFile, err := ioutil.TempFile("", "")
+		Expect(err).NotTo(HaveOccurred())
+
+		_, err = tempFile.WriteString(`---
+redact:
+  patterns: ["*_TOKEN"]
+  secrets: [API_KEY]
+`)
+		Expect(err).NotTo(HaveOccurred())
+
+		configFilePath = tempFile.Name()
+		Expect(tempFile.Close()).To(Succeed())
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(configFilePath)).To(Succeed())
+	})
+
+	It("parses the redact config", func() {
+		config, err := piper.PiperConfigParser{}.Parse(configFilePath)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(config).To(Equal(piper.PiperConfig{
+			Redact: &piper.RedactConfig{
+				Patterns: []string{"*_TOKEN"},
+				Secrets:  []string{"API_KEY"},
+			},
+		}))
+	})
+
+	It("parses an empty config", func() {
+		Expect(ioutil.WriteFile(configFilePath, []byte(""), 0644)).To(Succeed())
+
+		config, err := piper.PiperConfigParser{}.Parse(configFilePath)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(config).To(Equal(piper.PiperConfig{}))
+	})
+
+	Context("failure cases", func() {
+		It("rejects unknown keys", func() {
+			Expect(ioutil.WriteFile(configFilePath, []byte("redcat: {}"), 0644)).To(Succeed())
+
+			_, err := piper.PiperConfigParser{}.Parse(configFilePath)
+			Expect(err).To(MatchError(ContainSubstring("could not parse piper config")))
+			Expect(err).To(MatchError(ContainSubstring("field redcat not found")))
+		})
+
+		It("returns an error when the file does not exist", func() {
+			_, err := piper.PiperConfigParser{}.Parse("no-such-file")
+			Expect(err).To(MatchError(ContainSubstring("no such file or directory")))
+		})
+	})
+})
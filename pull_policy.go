@@ -0,0 +1,60 @@
+package piper
+
+import (
+	"fmt"
+	"strings"
+)
+
+// PullPolicy decides whether the task image is pulled before the task runs.
+type PullPolicy string
+
+const (
+	PullAlways  PullPolicy = "always"
+	PullMissing PullPolicy = "missing"
+	PullNever   PullPolicy = "never"
+)
+
+// PullPolicies lists the supported pull policies.
+var PullPolicies = []PullPolicy{PullAlways, PullMissing, PullNever}
+
+func ParsePullPolicy(policy string) (PullPolicy, error) {
+	var names []string
+	for _, pullPolicy := range PullPolicies {
+		if policy == string(pullPolicy) {
+			return pullPolicy, nil
+		}
+		names = append(names, string(pullPolicy))
+	}
+
+	return "", fmt.Errorf("unsupported pull policy %q: must be one of %s", policy, strings.Join(names, ", "))
+}
+
+// MissingImagesError is returned when images are never pulled, but are not
+// present locally.
+type MissingImagesError struct {
+	Images []string
+}
+
+func (e MissingImagesError) Error() string {
+	return fmt.Sprintf("the following images are not present locally: %s", strings.Join(e.Images, ", "))
+}
+
+// ShouldPull reports whether the image is pulled under the policy. Unless it
+// is always pulled, the image is first looked for with the runtime, and a
+// MissingImagesError is returned when it is missing but never pulled.
+func (p PullPolicy) ShouldPull(runtime Runtime, image string) (bool, error) {
+	if p == PullAlways {
+		return true, nil
+	}
+
+	exists, err := runtime.ImageExists(image)
+	if err != nil {
+		return false, err
+	}
+
+	if !exists && p == PullNever {
+		return false, MissingImagesError{Images: []string{image}}
+	}
+
+	return !exists, nil
+}
@@ -0,0 +1,74 @@
+package piper_test
+
+import (
+	"os/exec"
+
+	"github.com/ryanmoran/piper"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("PullPolicy", func() {
+	var present, missing, broken piper.DockerClient
+
+	BeforeEach(func() {
+		present = piper.DockerClient{Command: exec.Command("true")}
+		missing = piper.DockerClient{Command: exec.Command("sh", "-c", `echo "Error: No such image: $4" >&2; exit 1`, "--")}
+		broken = piper.DockerClient{Command: exec.Command("no-such-executable")}
+	})
+
+	Describe("ParsePullPolicy", func() {
+		It("parses the pull policies", func() {
+			for _, name := range []string{"always", "missing", "never"} {
+				pullPolicy, err := piper.ParsePullPolicy(name)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(pullPolicy).To(Equal(piper.PullPolicy(name)))
+			}
+		})
+
+		Context("failure cases", func() {
+			It("returns an error for an unsupported pull policy", func() {
+				_, err := piper.ParsePullPolicy("sometimes")
+				Expect(err).To(MatchError(`unsupported pull policy "sometimes": must be one of always, missing, never`))
+			})
+		})
+	})
+
+	Describe("ShouldPull", func() {
+		It("always pulls without looking for the image", func() {
+			pull, err := piper.PullAlways.ShouldPull(broken, "my-image")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(pull).To(BeTrue())
+		})
+
+		It("pulls missing images", func() {
+			pull, err := piper.PullMissing.ShouldPull(missing, "my-image")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(pull).To(BeTrue())
+
+			pull, err = piper.PullMissing.ShouldPull(present, "my-image")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(pull).To(BeFalse())
+		})
+
+		It("never pulls present images", func() {
+			pull, err := piper.PullNever.ShouldPull(present, "my-image")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(pull).To(BeFalse())
+		})
+
+		Context("failure cases", func() {
+			It("returns the missing image when it is never pulled", func() {
+				_, err := piper.PullNever.ShouldPull(missing, "my-image")
+				Expect(err).To(Equal(piper.MissingImagesError{Images: []string{"my-image"}}))
+				Expect(err).To(MatchError("the following images are not present locally: my-image"))
+			})
+
+			It("returns an error when the image cannot be looked for", func() {
+				_, err := piper.PullMissing.ShouldPull(broken, "my-image")
+				Expect(err).To(MatchError(ContainSubstring("executable file not found in $PATH")))
+			})
+		})
+	})
+})
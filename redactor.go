@@ -0,0 +1,59 @@
+package piper
+
+import (
+	"path"
+	"strings"
+)
+
+var DefaultRedactPatterns = []string{"*_TOKEN", "*PASSWORD*", "*SECRET*", "*_KEY"}
+
+// EnvRedactor marks environment variables as secret when their key matches
+// one of the glob Patterns or is listed in Keys, ignoring case, or when their
+// value is one of the secret Values, or contains one that is not shorter than
+// MinRedactedLength.
+type EnvRedactor struct {
+	Patterns []string
+	Keys     []string
+	Values   []string
+}
+
+func (r EnvRedactor) IsSecret(key string) bool {
+	key = strings.ToUpper(key)
+
+	for _, secretKey := range r.Keys {
+		if strings.ToUpper(secretKey) == key {
+			return true
+		}
+	}
+
+	for _, pattern := range r.Patterns {
+		if matched, err := path.Match(strings.ToUpper(pattern), key); err == nil && matched {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (r EnvRedactor) Redact(envVars []DockerEnv) []DockerEnv {
+	var redacted []DockerEnv
+	for _, envVar := range envVars {
+		envVar.Secret = envVar.Secret || r.IsSecret(envVar.Key) || r.hasSecretValue(envVar.Value)
+		redacted = append(redacted, envVar)
+	}
+
+	return redacted
+}
+
+func (r EnvRedactor) hasSecretValue(value string) bool {
+	for _, secret := range r.Values {
+		if secret == "" {
+			continue
+		}
+		if value == secret || (len(secret) >= MinRedactedLength && strings.Contains(value, secret)) {
+			return true
+		}
+	}
+
+	return false
+}
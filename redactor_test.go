@@ -0,0 +1,73 @@
+package piper_test
+
+import (
+	"github.com/ryanmoran/piper"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("EnvRedactor", func() {
+	It("marks variables matching a pattern or key as secret", func() {
+		redactor := piper.EnvRedactor{
+			Patterns: []string{"*_TOKEN", "*password*"},
+			Keys:     []string{"api_key"},
+		}
+
+		envVars := redactor.Redact([]piper.DockerEnv{
+			{Key: "GITHUB_TOKEN", Value: "some-token"},
+			{Key: "DB_PASSWORD_FILE", Value: "some-file"},
+			{Key: "API_KEY", Value: "some-key"},
+			{Key: "TOKEN_URL", Value: "some-url"},
+			{Key: "ALREADY_SECRET", Value: "some-value", Secret: true},
+		})
+
+		Expect(envVars).To(Equal([]piper.DockerEnv{
+			{Key: "GITHUB_TOKEN", Value: "some-token", Secret: true},
+			{Key: "DB_PASSWORD_FILE", Value: "some-file", Secret: true},
+			{Key: "API_KEY", Value: "some-key", Secret: true},
+			{Key: "TOKEN_URL", Value: "some-url"},
+			{Key: "ALREADY_SECRET", Value: "some-value", Secret: true},
+		}))
+	})
+
+	It("marks variables whose value contains a secret value as secret", func() {
+		redactor := piper.EnvRedactor{Values: []string{"some-secret", ""}}
+
+		envVars := redactor.Redact([]piper.DockerEnv{
+			{Key: "TOKEN", Value: "some-secret"},
+			{Key: "AUTHORIZATION", Value: "Bearer some-secret"},
+			{Key: "NAME", Value: "some-name"},
+		})
+
+		Expect(envVars).To(Equal([]piper.DockerEnv{
+			{Key: "TOKEN", Value: "some-secret", Secret: true},
+			{Key: "AUTHORIZATION", Value: "Bearer some-secret", Secret: true},
+			{Key: "NAME", Value: "some-name"},
+		}))
+	})
+
+	It("only marks variables whose value is a short secret value", func() {
+		redactor := piper.EnvRedactor{Values: []string{"1"}}
+
+		envVars := redactor.Redact([]piper.DockerEnv{
+			{Key: "RETRIES", Value: "1"},
+			{Key: "VERSION", Value: "1.0"},
+		})
+
+		Expect(envVars).To(Equal([]piper.DockerEnv{
+			{Key: "RETRIES", Value: "1", Secret: true},
+			{Key: "VERSION", Value: "1.0"},
+		}))
+	})
+
+	It("matches the default patterns", func() {
+		redactor := piper.EnvRedactor{Patterns: piper.DefaultRedactPatterns}
+
+		Expect(redactor.IsSecret("NPM_TOKEN")).To(BeTrue())
+		Expect(redactor.IsSecret("PASSWORD")).To(BeTrue())
+		Expect(redactor.IsSecret("CLIENT_SECRET_ID")).To(BeTrue())
+		Expect(redactor.IsSecret("AWS_ACCESS_KEY")).To(BeTrue())
+		Expect(redactor.IsSecret("KEYBOARD")).To(BeFalse())
+	})
+})
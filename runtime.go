@@ -0,0 +1,150 @@
+package piper
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+)
+
+const (
+	DockerRuntime  = "docker"
+	PodmanRuntime  = "podman"
+	NerdctlRuntime = "nerdctl"
+)
+
+// Runtimes lists the supported container runtime CLIs in the order they are
+// looked for on the $PATH.
+var Runtimes = []string{DockerRuntime, PodmanRuntime, NerdctlRuntime}
+
+// Runtime logs in to registries, finds and pulls images and runs task
+// containers. It is implemented by DockerClient, PodmanClient and
+// DockerEngineClient.
+type Runtime interface {
+	Login(auth DockerRegistryAuth, dryRun bool) error
+	ImageExists(image string) (bool, error)
+	Pull(image string, dryRun bool) error
+	Run(command []string, image string, envVars []DockerEnv, mounts []DockerVolumeMount, options DockerRunOptions, dryRun bool) error
+	RunContext(ctx context.Context, command []string, image string, envVars []DockerEnv, mounts []DockerVolumeMount, options DockerRunOptions, dryRun bool) error
+}
+
+// DetectRuntime returns the first of Runtimes found on the $PATH, or docker
+// when none of them are.
+func DetectRuntime() string {
+	for _, name := range Runtimes {
+		if _, err := exec.LookPath(name); err == nil {
+			return name
+		}
+	}
+
+	return DockerRuntime
+}
+
+// NewRuntime returns a Runtime that runs the CLI of the named runtime, found on
+// the $PATH. nerdctl accepts the same commands as the docker CLI.
+func NewRuntime(name string, stdin io.Reader, stdout, stderr io.Writer) (Runtime, error) {
+	switch name {
+	case DockerRuntime, PodmanRuntime, NerdctlRuntime:
+	default:
+		return nil, fmt.Errorf("unsupported runtime %q: must be one of %s", name, strings.Join(Runtimes, ", "))
+	}
+
+	runtimePath, err := exec.LookPath(name)
+	if err != nil {
+		return nil, err
+	}
+
+	client := DockerClient{
+		Command: exec.Command(runtimePath),
+		Stdin:   stdin,
+		Stdout:  stdout,
+		Stderr:  stderr,
+	}
+
+	if name == PodmanRuntime {
+		return PodmanClient{DockerClient: client}, nil
+	}
+
+	return client, nil
+}
+
+// WithRegistryConfigDir returns the runtime with the credentials of Login kept
+// in dir instead of the user's config, so that they can be removed after the
+// pull. The Docker Engine API client only keeps credentials in memory, and is
+// returned as it is.
+func WithRegistryConfigDir(runtime Runtime, dir string) Runtime {
+	switch client := runtime.(type) {
+	case DockerClient:
+		client.RegistryConfigDir = dir
+		return client
+	case PodmanClient:
+		client.RegistryConfigDir = dir
+		return client
+	}
+
+	return runtime
+}
+
+// PodmanClient runs the podman CLI. Rootless podman runs containers in a user
+// namespace, so the host user is mapped into the container with
+// --userns=keep-id to keep mounted inputs and outputs owned by that user.
+// Images on Docker Hub are qualified with docker.io, as podman does not assume
+// a default registry.
+type PodmanClient struct {
+	DockerClient
+}
+
+func (c PodmanClient) ImageExists(image string) (bool, error) {
+	return c.DockerClient.ImageExists(qualifyImage(image))
+}
+
+func (c PodmanClient) Pull(image string, dryRun bool) error {
+	return c.DockerClient.Pull(qualifyImage(image), dryRun)
+}
+
+func (c PodmanClient) Run(
+	command []string,
+	image string,
+	envVars []DockerEnv,
+	mounts []DockerVolumeMount,
+	options DockerRunOptions,
+	dryRun bool,
+) error {
+	return c.RunContext(context.Background(), command, image, envVars, mounts, options, dryRun)
+}
+
+func (c PodmanClient) RunContext(
+	ctx context.Context,
+	command []string,
+	image string,
+	envVars []DockerEnv,
+	mounts []DockerVolumeMount,
+	options DockerRunOptions,
+	dryRun bool,
+) error {
+	var runArgs []string
+	if options.HostUser {
+		runArgs = append(runArgs, "--userns=keep-id")
+	}
+
+	return c.DockerClient.run(ctx, command, qualifyImage(image), envVars, mounts, options, dryRun, runArgs)
+}
+
+// qualifyImage prefixes images on Docker Hub with docker.io, adding the
+// library namespace of official images.
+func qualifyImage(image string) string {
+	if registryHost(image) != "" {
+		return image
+	}
+
+	name := image
+	if i := strings.IndexAny(name, ":@"); i != -1 {
+		name = name[:i]
+	}
+	if !strings.Contains(name, "/") {
+		return "docker.io/library/" + image
+	}
+
+	return "docker.io/" + image
+}
@@ -0,0 +1,162 @@
+package piper_test
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/ryanmoran/piper"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Runtime", func() {
+	var (
+		tempDir string
+		path    string
+	)
+
+	BeforeEach(func() {
+		var err error
+		tempDir, err = ioutil.TempDir("", "")
+		Expect(err).NotTo(HaveOccurred())
+
+		path = os.Getenv("PATH")
+		os.Setenv("PATH", tempDir)
+	})
+
+	AfterEach(func() {
+		os.Setenv("PATH", path)
+		Expect(os.RemoveAll(tempDir)).To(Succeed())
+	})
+
+	Describe("DetectRuntime", func() {
+		It("returns the first runtime found on the $PATH", func() {
+			Expect(ioutil.WriteFile(filepath.Join(tempDir, "nerdctl"), []byte("#!/bin/sh\n"), 0755)).To(Succeed())
+			Expect(piper.DetectRuntime()).To(Equal("nerdctl"))
+
+			Expect(ioutil.WriteFile(filepath.Join(tempDir, "podman"), []byte("#!/bin/sh\n"), 0755)).To(Succeed())
+			Expect(piper.DetectRuntime()).To(Equal("podman"))
+		})
+
+		It("returns docker when no runtime is found", func() {
+			Expect(piper.DetectRuntime()).To(Equal("docker"))
+		})
+	})
+
+	Describe("NewRuntime", func() {
+		It("returns a podman client for podman", func() {
+			Expect(ioutil.WriteFile(filepath.Join(tempDir, "podman"), []byte("#!/bin/sh\n"), 0755)).To(Succeed())
+
+			runtime, err := piper.NewRuntime("podman", nil, nil, nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(runtime).To(BeAssignableToTypeOf(piper.PodmanClient{}))
+			Expect(runtime.(piper.PodmanClient).Command.Path).To(Equal(filepath.Join(tempDir, "podman")))
+		})
+
+		It("returns a docker client for nerdctl", func() {
+			Expect(ioutil.WriteFile(filepath.Join(tempDir, "nerdctl"), []byte("#!/bin/sh\n"), 0755)).To(Succeed())
+
+			runtime, err := piper.NewRuntime("nerdctl", nil, nil, nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(runtime).To(BeAssignableToTypeOf(piper.DockerClient{}))
+			Expect(runtime.(piper.DockerClient).Command.Path).To(Equal(filepath.Join(tempDir, "nerdctl")))
+		})
+
+		Context("failure cases", func() {
+			It("returns an error for an unsupported runtime", func() {
+				_, err := piper.NewRuntime("rkt", nil, nil, nil)
+				Expect(err).To(MatchError(`unsupported runtime "rkt": must be one of docker, podman, nerdctl`))
+			})
+
+			It("returns an error when the runtime is not on the $PATH", func() {
+				_, err := piper.NewRuntime("podman", nil, nil, nil)
+				Expect(err).To(MatchError(ContainSubstring("executable file not found in $PATH")))
+			})
+		})
+	})
+
+	Describe("WithRegistryConfigDir", func() {
+		It("sets the registry config dir of the CLI runtimes", func() {
+			runtime := piper.WithRegistryConfigDir(piper.DockerClient{}, "/some/config-dir")
+			Expect(runtime.(piper.DockerClient).RegistryConfigDir).To(Equal("/some/config-dir"))
+
+			runtime = piper.WithRegistryConfigDir(piper.PodmanClient{}, "/some/config-dir")
+			Expect(runtime.(piper.PodmanClient).RegistryConfigDir).To(Equal("/some/config-dir"))
+		})
+
+		It("leaves the Docker Engine API client as it is", func() {
+			client := piper.DockerEngineClient{}
+			Expect(piper.WithRegistryConfigDir(client, "/some/config-dir")).To(BeAssignableToTypeOf(client))
+		})
+	})
+})
+
+var _ = Describe("PodmanClient", func() {
+	var (
+		client piper.PodmanClient
+		stdout *bytes.Buffer
+	)
+
+	BeforeEach(func() {
+		stdout = bytes.NewBuffer([]byte{})
+
+		client = piper.PodmanClient{
+			DockerClient: piper.DockerClient{
+				Command: exec.Command("echo"),
+				Stdout:  stdout,
+			},
+		}
+	})
+
+	It("qualifies Docker Hub images with docker.io", func() {
+		Expect(client.Pull("my-image", false)).To(Succeed())
+		Expect(client.Pull("my-org/my-image:1.0", false)).To(Succeed())
+		Expect(client.Pull("registry.example.com/my-image", false)).To(Succeed())
+
+		Expect(stdout.String()).To(Equal(`pull docker.io/library/my-image
+pull docker.io/my-org/my-image:1.0
+pull registry.example.com/my-image
+`))
+	})
+
+	It("looks for Docker Hub images qualified with docker.io", func() {
+		tempDir, err := ioutil.TempDir("", "")
+		Expect(err).NotTo(HaveOccurred())
+		defer os.RemoveAll(tempDir)
+
+		logPath := filepath.Join(tempDir, "invocations")
+		client.Command = exec.Command("sh", "-c", `echo "$@" >> "$0"`, logPath)
+
+		exists, err := client.ImageExists("my-image:1.0")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(exists).To(BeTrue())
+
+		invocations, err := ioutil.ReadFile(logPath)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(invocations)).To(Equal("image inspect --format={{.Id}} docker.io/library/my-image:1.0\n"))
+	})
+
+	It("maps the host user into the container with --userns=keep-id", func() {
+		err := client.Run([]string{"my-task.sh"}, "my-image:1.0", nil, nil, piper.DockerRunOptions{
+			User:     "task-user",
+			HostUser: true,
+		}, false)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(stdout.String()).To(Equal(fmt.Sprintf("run --workdir=/tmp/build --userns=keep-id --user=%d:%d docker.io/library/my-image:1.0 my-task.sh\n", os.Getuid(), os.Getgid())))
+	})
+
+	It("runs as the given user without the host user", func() {
+		err := client.Run([]string{"my-task.sh"}, "my-image", nil, nil, piper.DockerRunOptions{
+			User: "task-user",
+		}, false)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(stdout.String()).To(Equal("run --workdir=/tmp/build --user=task-user docker.io/library/my-image my-task.sh\n"))
+	})
+})
@@ -0,0 +1,14 @@
+package piper
+
+import (
+	"os"
+
+	"golang.org/x/term"
+)
+
+// IsTerminal reports whether the file is a terminal, which the runtimes need
+// to allocate a tty for the task. Character devices that are not terminals,
+// like /dev/null, are not.
+func IsTerminal(file *os.File) bool {
+	return term.IsTerminal(int(file.Fd()))
+}
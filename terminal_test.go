@@ -0,0 +1,28 @@
+package piper_test
+
+import (
+	"os"
+
+	"github.com/ryanmoran/piper"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("IsTerminal", func() {
+	It("does not report /dev/null as a terminal", func() {
+		devNull, err := os.Open(os.DevNull)
+		Expect(err).NotTo(HaveOccurred())
+		defer devNull.Close()
+
+		Expect(piper.IsTerminal(devNull)).To(BeFalse())
+	})
+
+	It("does not report a regular file as a terminal", func() {
+		file, err := os.Open("terminal_test.go")
+		Expect(err).NotTo(HaveOccurred())
+		defer file.Close()
+
+		Expect(piper.IsTerminal(file)).To(BeFalse())
+	})
+})
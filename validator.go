@@ -0,0 +1,229 @@
+package piper
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+type ValidationError struct {
+	Line    int
+	Column  int
+	Message string
+}
+
+func (e ValidationError) Error() string {
+	return fmt.Sprintf("line %d, column %d: %s", e.Line, e.Column, e.Message)
+}
+
+type ValidationErrors []ValidationError
+
+func (e ValidationErrors) Error() string {
+	var messages []string
+	for _, err := range e {
+		messages = append(messages, err.Error())
+	}
+
+	return strings.Join(messages, "\n")
+}
+
+// schema lists the keys allowed in each mapping of a task config. Mappings
+// that are not listed, like params and image_resource.source, accept any key.
+var schema = map[string][]string{
+	"":                 {"platform", "image", "rootfs_uri", "image_resource", "run", "inputs", "outputs", "caches", "params", "container_limits"},
+	"run":              {"path", "args", "dir", "user"},
+	"inputs":           {"name", "path", "optional"},
+	"outputs":          {"name", "path"},
+	"caches":           {"path"},
+	"image_resource":   {"type", "source", "params", "version"},
+	"container_limits": {"cpu", "memory"},
+}
+
+// Validator checks task config documents. When ImageGiven is set, the image
+// is given outside the task config, like with -r, so the task config does not
+// need one.
+type Validator struct {
+	ImageGiven bool
+}
+
+// Validate checks a task config document for unknown keys, a missing run
+// path or image, and inputs, outputs and caches that collide with each other
+// or are not inside of the build directory.
+func (v Validator) Validate(document *yaml.Node) error {
+	root := document
+	if root.Kind == yaml.DocumentNode && len(root.Content) > 0 {
+		root = root.Content[0]
+	}
+
+	var errs ValidationErrors
+	if root.Kind != yaml.MappingNode {
+		errs = append(errs, validationError(root, "task config must be a mapping"))
+		return errs
+	}
+
+	errs = append(errs, v.validateKeys(root, "")...)
+
+	run := mappingValue(root, "run")
+	if run == nil || scalarValue(mappingValue(run, "path")) == "" {
+		node := root
+		if run != nil {
+			node = run
+		}
+		errs = append(errs, validationError(node, "missing required field run.path"))
+	}
+
+	if !v.ImageGiven && scalarValue(mappingValue(root, "image")) == "" && scalarValue(mappingValue(root, "rootfs_uri")) == "" && mappingValue(root, "image_resource") == nil {
+		errs = append(errs, validationError(root, "missing image: one of image, rootfs_uri or image_resource is required"))
+	}
+
+	errs = append(errs, v.validateResources(root)...)
+
+	sort.SliceStable(errs, func(i, j int) bool {
+		if errs[i].Line == errs[j].Line {
+			return errs[i].Column < errs[j].Column
+		}
+		return errs[i].Line < errs[j].Line
+	})
+
+	if len(errs) > 0 {
+		return errs
+	}
+
+	return nil
+}
+
+func (v Validator) validateKeys(node *yaml.Node, section string) ValidationErrors {
+	allowed := map[string]bool{}
+	for _, key := range schema[section] {
+		allowed[key] = true
+	}
+
+	var errs ValidationErrors
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+		if !allowed[key.Value] {
+			name := key.Value
+			if section != "" {
+				name = fmt.Sprintf("%s.%s", section, key.Value)
+			}
+			errs = append(errs, validationError(key, fmt.Sprintf("unknown field %q", name)))
+			continue
+		}
+
+		child := key.Value
+		if section != "" {
+			child = fmt.Sprintf("%s.%s", section, key.Value)
+		}
+		if _, ok := schema[child]; !ok {
+			continue
+		}
+
+		switch value.Kind {
+		case yaml.MappingNode:
+			errs = append(errs, v.validateKeys(value, child)...)
+		case yaml.SequenceNode:
+			for _, item := range value.Content {
+				if item.Kind == yaml.MappingNode {
+					errs = append(errs, v.validateKeys(item, child)...)
+				}
+			}
+		}
+	}
+
+	return errs
+}
+
+func (v Validator) validateResources(root *yaml.Node) ValidationErrors {
+	var errs ValidationErrors
+	names := map[string]string{}
+	paths := map[string]string{}
+
+	for _, section := range []string{"inputs", "outputs", "caches"} {
+		resources := mappingValue(root, section)
+		if resources == nil || resources.Kind != yaml.SequenceNode {
+			continue
+		}
+
+		for _, resource := range resources.Content {
+			name := scalarValue(mappingValue(resource, "name"))
+			path := scalarValue(mappingValue(resource, "path"))
+
+			if section != "caches" {
+				if name == "" {
+					errs = append(errs, validationError(resource, fmt.Sprintf("%s entry is missing a name", section)))
+					continue
+				}
+
+				if previous, ok := names[name]; ok {
+					errs = append(errs, validationError(resource, fmt.Sprintf("duplicate name %q, already used by %s", name, previous)))
+					continue
+				}
+				names[name] = fmt.Sprintf("%s %q", section, name)
+			} else if path == "" {
+				errs = append(errs, validationError(resource, "caches entry is missing a path"))
+				continue
+			}
+
+			if path == "" {
+				path = name
+			}
+
+			description := fmt.Sprintf("%s %q", section, name)
+			if section == "caches" {
+				description = fmt.Sprintf("%s %q", section, path)
+			}
+
+			mountPoint := filepath.Join(VolumeMountPoint, path)
+			if !strings.HasPrefix(mountPoint, VolumeMountPoint+"/") {
+				errs = append(errs, validationError(resource, fmt.Sprintf("path %q of %s must be inside of %s", path, description, VolumeMountPoint)))
+				continue
+			}
+
+			if previous, ok := paths[mountPoint]; ok {
+				errs = append(errs, validationError(resource, fmt.Sprintf("path %q of %s conflicts with %s", path, description, previous)))
+			} else {
+				paths[mountPoint] = description
+			}
+		}
+	}
+
+	return errs
+}
+
+func mappingValue(node *yaml.Node, key string) *yaml.Node {
+	if node == nil || node.Kind != yaml.MappingNode {
+		return nil
+	}
+
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+
+	return nil
+}
+
+func scalarValue(node *yaml.Node) string {
+	if node == nil || node.Kind != yaml.ScalarNode {
+		return ""
+	}
+
+	return node.Value
+}
+
+func validationError(node *yaml.Node, message string) ValidationError {
+	line, column := node.Line, node.Column
+	if line == 0 {
+		line, column = 1, 1
+	}
+
+	return ValidationError{
+		Line:    line,
+		Column:  column,
+		Message: message,
+	}
+}
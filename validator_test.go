@@ -0,0 +1,150 @@
+package piper_test
+
+import (
+	"github.com/ryanmoran/piper"
+	"gopkg.in/yaml.v3"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Validator", func() {
+	var validate = func(contents string) error {
+		var document yaml.Node
+		err := yaml.Unmarshal([]byte(contents), &document)
+		Expect(err).NotTo(HaveOccurred())
+
+		return piper.Validator{}.Validate(&document)
+	}
+
+	It("accepts a valid task config", func() {
+		err := validate(`---
+platform: linux
+image_resource:
+  type: registry-image
+  source: {repository: some-image, some-resource-specific-key: value}
+run:
+  path: some-command
+  args: [some-arg]
+  dir: some-dir
+  user: some-user
+inputs:
+  - name: input-1
+    path: some/path
+    optional: true
+outputs:
+  - name: output-1
+caches:
+  - path: cache-1
+params:
+  ANY_KEY: any-value
+container_limits:
+  cpu: 512
+  memory: 1GB
+`)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	Context("failure cases", func() {
+		It("rejects unknown keys with their line and column", func() {
+			err := validate(`---
+image: some-image
+run:
+  path: some-command
+  agrs: [some-arg]
+ouputs:
+  - name: output-1
+inputs:
+  - name: input-1
+    optinal: true
+`)
+			Expect(err).To(Equal(piper.ValidationErrors{
+				{Line: 5, Column: 3, Message: `unknown field "run.agrs"`},
+				{Line: 6, Column: 1, Message: `unknown field "ouputs"`},
+				{Line: 10, Column: 5, Message: `unknown field "inputs.optinal"`},
+			}))
+		})
+
+		It("rejects a missing run.path", func() {
+			err := validate(`---
+image: some-image
+run:
+  args: [some-arg]
+`)
+			Expect(err).To(MatchError("line 4, column 3: missing required field run.path"))
+		})
+
+		It("rejects a missing image", func() {
+			err := validate(`---
+run:
+  path: some-command
+`)
+			Expect(err).To(MatchError("line 2, column 1: missing image: one of image, rootfs_uri or image_resource is required"))
+		})
+
+		It("accepts a missing image when it is given outside the task config", func() {
+			var document yaml.Node
+			Expect(yaml.Unmarshal([]byte("run: {path: some-command}\n"), &document)).To(Succeed())
+
+			Expect(piper.Validator{ImageGiven: true}.Validate(&document)).To(Succeed())
+		})
+
+		It("rejects duplicate input and output names", func() {
+			err := validate(`---
+image: some-image
+run: {path: some-command}
+inputs:
+  - name: some-name
+  - name: some-name
+outputs:
+  - name: some-name
+    path: some-output
+`)
+			Expect(err).To(Equal(piper.ValidationErrors{
+				{Line: 6, Column: 5, Message: `duplicate name "some-name", already used by inputs "some-name"`},
+				{Line: 8, Column: 5, Message: `duplicate name "some-name", already used by inputs "some-name"`},
+			}))
+		})
+
+		It("rejects conflicting paths", func() {
+			err := validate(`---
+image: some-image
+run: {path: some-command}
+inputs:
+  - name: input-1
+    path: some/path
+outputs:
+  - name: output-1
+    path: some/path/
+caches:
+  - path: ./some/path
+`)
+			Expect(err).To(MatchError(`line 8, column 5: path "some/path/" of outputs "output-1" conflicts with inputs "input-1"
+line 11, column 5: path "./some/path" of caches "./some/path" conflicts with inputs "input-1"`))
+		})
+
+		It("rejects paths outside of the build directory", func() {
+			err := validate(`---
+image: some-image
+run: {path: some-command}
+inputs:
+  - name: input-1
+    path: ../../etc
+  - name: ..
+outputs:
+  - name: output-1
+    path: .
+caches:
+  - path: /some/path
+`)
+			Expect(err).To(MatchError(`line 5, column 5: path "../../etc" of inputs "input-1" must be inside of /tmp/build
+line 7, column 5: path ".." of inputs ".." must be inside of /tmp/build
+line 9, column 5: path "." of outputs "output-1" must be inside of /tmp/build`))
+		})
+
+		It("rejects a task config that is not a mapping", func() {
+			err := validate(`- some-item`)
+			Expect(err).To(MatchError("line 1, column 1: task config must be a mapping"))
+		})
+	})
+})
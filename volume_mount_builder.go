@@ -4,14 +4,32 @@ import (
 	"fmt"
 	"os/user"
 	"path/filepath"
+	"sort"
 	"strings"
 )
 
 const VolumeMountPoint = "/tmp/build"
 
-type VolumeMountBuilder struct{}
+// VolumeMountBuilder mounts the given inputs and outputs into the task
+// container. InputMapping and OutputMapping hold <task-name>=<alias> pairs that
+// let a task resource be satisfied by an input or output given under another
+// name, like the input_mapping and output_mapping of a pipeline task step.
+type VolumeMountBuilder struct {
+	InputMapping  []string
+	OutputMapping []string
+}
 
 func (b VolumeMountBuilder) Build(resources []VolumeMount, inputs, outputs []string) ([]DockerVolumeMount, error) {
+	mappings, err := b.mappings(resources)
+	if err != nil {
+		return nil, err
+	}
+
+	aliases := make(map[string]bool)
+	for _, alias := range mappings {
+		aliases[alias] = true
+	}
+
 	pairsMap := make(map[string]string)
 
 	for _, input := range inputs {
@@ -53,10 +71,22 @@ func (b VolumeMountBuilder) Build(resources []VolumeMount, inputs, outputs []str
 			continue
 		}
 
-		resourceLocation, ok := pairsMap[resource.Name]
+		name := resource.Name
+		if alias, ok := mappings[resource.Name]; ok {
+			if _, given := pairsMap[resource.Name]; given && alias != resource.Name && !aliases[resource.Name] {
+				return nil, fmt.Errorf("%q is ambiguous: it was given both directly and through its mapping to %q", resource.Name, alias)
+			}
+			name = alias
+		}
+
+		resourceLocation, ok := pairsMap[name]
 		if !ok {
 			if !resource.Optional {
-				missingResources = append(missingResources, resource.Name)
+				if name != resource.Name {
+					missingResources = append(missingResources, fmt.Sprintf("%s (mapped to %s)", resource.Name, name))
+				} else {
+					missingResources = append(missingResources, resource.Name)
+				}
 			}
 			continue
 		}
@@ -79,6 +109,43 @@ func (b VolumeMountBuilder) Build(resources []VolumeMount, inputs, outputs []str
 	return mounts, nil
 }
 
+func (b VolumeMountBuilder) mappings(resources []VolumeMount) (map[string]string, error) {
+	mappings := make(map[string]string)
+	kinds := []string{"input mapping", "output mapping"}
+	for i, pairs := range [][]string{b.InputMapping, b.OutputMapping} {
+		kind := kinds[i]
+		for _, pair := range pairs {
+			parts := strings.Split(pair, "=")
+			if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+				return nil, fmt.Errorf("could not parse %s %q. must be of form <task-name>=<alias>", kind, pair)
+			}
+
+			if alias, ok := mappings[parts[0]]; ok && alias != parts[1] {
+				return nil, fmt.Errorf("%q is ambiguous: it is mapped to both %q and %q", parts[0], alias, parts[1])
+			}
+			mappings[parts[0]] = parts[1]
+		}
+	}
+
+	names := make(map[string]bool)
+	for _, resource := range resources {
+		names[resource.Name] = true
+	}
+
+	var unknown []string
+	for name := range mappings {
+		if !names[name] {
+			unknown = append(unknown, name)
+		}
+	}
+	if len(unknown) != 0 {
+		sort.Strings(unknown)
+		return nil, fmt.Errorf("The following mapped names are not inputs or outputs of the task: %s.", strings.Join(unknown, ", "))
+	}
+
+	return mappings, nil
+}
+
 func expandUser(path string) (string, error) {
 	if !strings.HasPrefix(path, "~/") {
 		return path, nil
@@ -10,6 +10,10 @@ import (
 var _ = Describe("VolumeMountBuilder", func() {
 	var builder piper.VolumeMountBuilder
 
+	BeforeEach(func() {
+		builder = piper.VolumeMountBuilder{}
+	})
+
 	Describe("Build", func() {
 		It("builds the volume mounts", func() {
 			mounts, err := builder.Build([]piper.VolumeMount{
@@ -96,7 +100,119 @@ var _ = Describe("VolumeMountBuilder", func() {
 			}))
 		})
 
+		It("resolves mapped input and output names", func() {
+			builder = piper.VolumeMountBuilder{
+				InputMapping:  []string{"input-1=repo"},
+				OutputMapping: []string{"output-1=built-repo"},
+			}
+
+			mounts, err := builder.Build([]piper.VolumeMount{
+				piper.VolumeMount{Name: "input-1"},
+				piper.VolumeMount{Name: "input-2"},
+				piper.VolumeMount{Name: "output-1"},
+			}, []string{
+				"repo=/some/path-1",
+				"input-2=/some/path-2",
+			}, []string{
+				"built-repo=/some/path-3",
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(mounts).To(Equal([]piper.DockerVolumeMount{
+				{
+					LocalPath:  "/some/path-1",
+					RemotePath: "/tmp/build/input-1",
+				},
+				{
+					LocalPath:  "/some/path-2",
+					RemotePath: "/tmp/build/input-2",
+				},
+				{
+					LocalPath:  "/some/path-3",
+					RemotePath: "/tmp/build/output-1",
+				},
+			}))
+		})
+
+		It("allows mapped names to be swapped", func() {
+			builder = piper.VolumeMountBuilder{
+				InputMapping: []string{"input-1=input-2", "input-2=input-1"},
+			}
+
+			mounts, err := builder.Build([]piper.VolumeMount{
+				piper.VolumeMount{Name: "input-1"},
+				piper.VolumeMount{Name: "input-2"},
+			}, []string{
+				"input-1=/some/path-1",
+				"input-2=/some/path-2",
+			}, []string{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(mounts).To(Equal([]piper.DockerVolumeMount{
+				{
+					LocalPath:  "/some/path-2",
+					RemotePath: "/tmp/build/input-1",
+				},
+				{
+					LocalPath:  "/some/path-1",
+					RemotePath: "/tmp/build/input-2",
+				},
+			}))
+		})
+
 		Context("failure cases", func() {
+			Context("when a mapping is malformed", func() {
+				It("returns an error", func() {
+					builder = piper.VolumeMountBuilder{InputMapping: []string{"input-1"}}
+
+					_, err := builder.Build([]piper.VolumeMount{{Name: "input-1"}}, []string{}, []string{})
+					Expect(err).To(MatchError(`could not parse input mapping "input-1". must be of form <task-name>=<alias>`))
+				})
+			})
+
+			Context("when a name is mapped to more than one alias", func() {
+				It("returns an error", func() {
+					builder = piper.VolumeMountBuilder{
+						InputMapping:  []string{"some-name=alias-1"},
+						OutputMapping: []string{"some-name=alias-2"},
+					}
+
+					_, err := builder.Build([]piper.VolumeMount{{Name: "some-name"}}, []string{}, []string{})
+					Expect(err).To(MatchError(`"some-name" is ambiguous: it is mapped to both "alias-1" and "alias-2"`))
+				})
+			})
+
+			Context("when a mapped name is also given directly", func() {
+				It("returns an error", func() {
+					builder = piper.VolumeMountBuilder{InputMapping: []string{"input-1=repo"}}
+
+					_, err := builder.Build([]piper.VolumeMount{{Name: "input-1"}}, []string{
+						"input-1=/some/path-1",
+						"repo=/some/path-2",
+					}, []string{})
+					Expect(err).To(MatchError(`"input-1" is ambiguous: it was given both directly and through its mapping to "repo"`))
+				})
+			})
+
+			Context("when a mapped name is not an input or output of the task", func() {
+				It("returns an error", func() {
+					builder = piper.VolumeMountBuilder{InputMapping: []string{"input-2=repo", "input-3=repo"}}
+
+					_, err := builder.Build([]piper.VolumeMount{{Name: "input-1"}}, []string{}, []string{})
+					Expect(err).To(MatchError("The following mapped names are not inputs or outputs of the task: input-2, input-3."))
+				})
+			})
+
+			Context("when a mapped input is not specified, but is required", func() {
+				It("returns an error", func() {
+					builder = piper.VolumeMountBuilder{InputMapping: []string{"input-1=repo"}}
+
+					_, err := builder.Build([]piper.VolumeMount{
+						{Name: "input-1"},
+						{Name: "input-2"},
+					}, []string{}, []string{})
+					Expect(err).To(MatchError(`The following required inputs/outputs are not satisfied: input-1 (mapped to repo), input-2.`))
+				})
+			})
+
 			Context("when the input pairs are malformed", func() {
 				It("returns an error", func() {
 					_, err := builder.Build([]piper.VolumeMount{}, []string{